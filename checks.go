@@ -0,0 +1,1939 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/alchen99/pg_ready_check/readycheck"
+)
+
+// buildChecks builds the readiness checks the options ask for, in the order they run and are
+// reported in, with those in -skip-checks disabled. connConfig is for the checks that connect to
+// other databases of the server. The error is for options that rule out a check.
+func buildChecks(o *options, connConfig *pgx.ConnConfig) ([]readycheck.Check, error) {
+	requiredTables := foldIdentifiers(o.mainTables, o.exactCase)
+	requiredExts := parseTableList(o.extensions)
+	requiredAvailableExts := parseTableList(o.availableExts)
+	requiredRLSTables := foldIdentifiers(parseTableList(o.requireRLS), o.exactCase)
+	requiredViews := foldIdentifiers(parseTableList(o.viewsToCheck), o.exactCase)
+	requiredIndexes := foldIdentifiers(parseTableList(o.indexesToCheck), o.exactCase)
+	requiredRoles := unquoteIdentifiers(foldIdentifiers(parseTableList(o.rolesToCheck), o.exactCase))
+	requiredTypes := foldIdentifiers(parseTableList(o.typesToCheck), o.exactCase)
+	requiredDomains := foldIdentifiers(parseTableList(o.domainsToCheck), o.exactCase)
+	requiredFDWServers := parseTableList(o.fdwServers)
+	requiredForeignTables := foldIdentifiers(parseTableList(o.foreignTables), o.exactCase)
+
+	var checks []readycheck.Check
+	if o.listenChannel != "" {
+		// First, so the other checks see what the notifier has finished setting up.
+		checks = append(checks, readycheck.Check{Name: "notification", Blocking: true, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			return waitForNotification(ctx, conn, o.listenChannel)
+		}})
+	}
+	// checkTables is the tables check, on conn to the database the tables are in.
+	checkTables := func(ctx context.Context, conn *pgx.Conn, requiredTables []string) error {
+		missingTables, err := readycheck.MissingTables(ctx, conn, requiredTables, o.defaultSchema, o.tableCheckQuery)
+		if err != nil {
+			return err
+		}
+		if o.tablesAbsent {
+			if present := presentObjects(requiredTables, missingTables); len(present) > 0 {
+				return readycheck.ObjectsNotReady("tables still present", readycheck.MissingObjects(present, ""))
+			}
+			slog.Debug("All tables absent", "tables", requiredTables)
+			return nil
+		}
+		if o.requireRows {
+			// Report missing and empty tables together, so one attempt shows everything that isn't seeded.
+			emptyTables, err := checkTablesNonEmpty(ctx, conn, presentObjects(requiredTables, missingTables), o.defaultSchema)
+			if err != nil {
+				return err
+			}
+			if len(missingTables) > 0 || len(emptyTables) > 0 {
+				return readycheck.ObjectsNotReady("required tables missing or empty",
+					append(readycheck.MissingObjects(missingTables, "missing"), readycheck.MissingObjects(emptyTables, "empty")...))
+			}
+		}
+		if len(missingTables) > 0 {
+			return readycheck.ObjectsNotReady("required tables missing", readycheck.MissingObjects(missingTables, ""))
+		}
+		slog.Debug("All required tables found", "tables", requiredTables)
+		return nil
+	}
+	// With -absent the checks say so in their names, since what fails them is a table that exists.
+	tablesCheckName, foreignTablesCheckName := "tables", "foreign tables"
+	if o.tablesAbsent {
+		tablesCheckName, foreignTablesCheckName = "tables absent", "foreign tables absent"
+	}
+	if len(requiredTables) > 0 {
+		checks = append(checks, readycheck.Check{Name: tablesCheckName, Objects: requiredTables, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			return checkTables(ctx, conn, requiredTables)
+		}})
+	}
+	for _, group := range o.otherDBTables {
+		// Same server and credentials; a connection of its own, opened for each attempt.
+		tables := foldIdentifiers(group.tables, o.exactCase)
+		checks = append(checks, readycheck.Check{Name: tablesCheckName + " in " + group.database, Objects: tables, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			dbConn, err := connectDatabase(ctx, connConfig, group.database)
+			if err != nil {
+				if readycheck.IsRetryable(err, o.retrySQLStateList) {
+					return readycheck.NotReady("%v", err) // The database may not be up or created yet
+				}
+				return err
+			}
+			defer dbConn.Close(context.Background())
+			return checkTables(ctx, dbConn, tables)
+		}})
+	}
+	if o.maxLockAge > 0 {
+		checks = append(checks, readycheck.Check{Name: "lock age", Objects: requiredTables, Privileged: true, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			locked, err := checkLongLocks(ctx, conn, requiredTables, o.defaultSchema, o.maxLockAge)
+			if err != nil {
+				return err
+			}
+			if len(locked) > 0 {
+				return readycheck.ObjectsNotReady(fmt.Sprintf("tables locked for longer than %s", o.maxLockAge), locked)
+			}
+			slog.Debug("No long-held locks on the tables", "max_lock_age", o.maxLockAge)
+			return nil
+		}})
+	}
+	if len(o.requiredColumns) > 0 {
+		checks = append(checks, readycheck.Check{Name: "columns", Objects: slices.Sorted(maps.Keys(o.requiredColumns)), Run: func(ctx context.Context, conn *pgx.Conn) error {
+			missing, err := checkColumnsExist(ctx, conn, o.requiredColumns, o.defaultSchema)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return readycheck.ObjectsNotReady("required columns missing", columnProblems(missing))
+			}
+			slog.Debug("All required columns found", "columns", o.columnsToCheck)
+			return nil
+		}})
+	}
+	if len(requiredViews) > 0 {
+		checks = append(checks, readycheck.Check{Name: "views", Objects: requiredViews, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			problems, err := checkViewsExist(ctx, conn, requiredViews, o.defaultSchema, o.viewsPopulated)
+			if err != nil {
+				return err
+			}
+			if len(problems) > 0 {
+				return readycheck.ObjectsNotReady("required views not ready", problems)
+			}
+			slog.Debug("All required views found", "views", o.viewsToCheck)
+			return nil
+		}})
+	}
+	if len(requiredIndexes) > 0 {
+		checks = append(checks, readycheck.Check{Name: "indexes", Objects: requiredIndexes, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			missing, err := checkIndexesExist(ctx, conn, requiredIndexes, o.defaultSchema)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return readycheck.ObjectsNotReady("required indexes missing", readycheck.MissingObjects(missing, ""))
+			}
+			slog.Debug("All required indexes found", "indexes", o.indexesToCheck)
+			return nil
+		}})
+	}
+	if len(requiredRoles) > 0 {
+		checks = append(checks, readycheck.Check{Name: "roles", Objects: requiredRoles, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			missing, err := checkRolesExist(ctx, conn, requiredRoles)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return readycheck.ObjectsNotReady("required roles missing", readycheck.MissingObjects(missing, ""))
+			}
+			slog.Debug("All required roles found", "roles", o.rolesToCheck)
+			return nil
+		}})
+	}
+	if len(requiredTypes) > 0 {
+		checks = append(checks, readycheck.Check{Name: "types", Objects: requiredTypes, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			missing, err := checkTypesExist(ctx, conn, requiredTypes, o.defaultSchema, "c")
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return readycheck.ObjectsNotReady("required composite types missing", readycheck.MissingObjects(missing, ""))
+			}
+			slog.Debug("All required composite types found", "types", o.typesToCheck)
+			return nil
+		}})
+	}
+	if len(requiredDomains) > 0 {
+		checks = append(checks, readycheck.Check{Name: "domains", Objects: requiredDomains, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			missing, err := checkTypesExist(ctx, conn, requiredDomains, o.defaultSchema, "d")
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return readycheck.ObjectsNotReady("required domains missing", readycheck.MissingObjects(missing, ""))
+			}
+			slog.Debug("All required domains found", "domains", o.domainsToCheck)
+			return nil
+		}})
+	}
+	if len(requiredRLSTables) > 0 {
+		checks = append(checks, readycheck.Check{Name: "row-level security", Objects: requiredRLSTables, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			lacking, notFound, err := checkRowLevelSecurity(ctx, conn, requiredRLSTables, o.defaultSchema, o.forceRLS)
+			if err != nil {
+				return err
+			}
+			if len(lacking) > 0 {
+				// RLS switched off on an existing table is a security regression, not a pending migration.
+				return readycheck.ObjectsMisconfigured("row-level security not enabled on tables", append(lacking, readycheck.MissingObjects(notFound, "table missing")...))
+			}
+			if len(notFound) > 0 {
+				return readycheck.ObjectsNotReady("tables for row-level security check missing", readycheck.MissingObjects(notFound, ""))
+			}
+			slog.Debug("Row-level security enabled on all tables", "tables", o.requireRLS)
+			return nil
+		}})
+	}
+	if len(o.accessMethodSpecs) > 0 {
+		checks = append(checks, readycheck.Check{Name: "table access methods", Objects: valueNames(o.accessMethodSpecs), Run: func(ctx context.Context, conn *pgx.Conn) error {
+			mismatched, notFound, err := checkTableAccessMethods(ctx, conn, o.accessMethodSpecs, o.defaultSchema)
+			if err != nil {
+				return err
+			}
+			if len(mismatched) > 0 {
+				// The table was created with the wrong storage; that needs a new migration.
+				return readycheck.ObjectsMisconfigured("tables using the wrong access method", append(mismatched, readycheck.MissingObjects(notFound, "table missing")...))
+			}
+			if len(notFound) > 0 {
+				return readycheck.ObjectsNotReady("tables for access method check missing", readycheck.MissingObjects(notFound, ""))
+			}
+			slog.Debug("All tables use the expected access methods", "access_methods", o.accessMethods)
+			return nil
+		}})
+	}
+	if len(o.sequenceMins) > 0 {
+		checks = append(checks, readycheck.Check{Name: "sequences", Objects: sequenceNames(o.sequenceMins), Run: func(ctx context.Context, conn *pgx.Conn) error {
+			values, short, err := checkSequences(ctx, conn, o.sequenceMins, o.defaultSchema)
+			if err != nil {
+				return err
+			}
+			if len(short) > 0 {
+				return readycheck.ObjectsNotReady("sequences below their minimum", short)
+			}
+			slog.Debug("All sequences at their minimum", "values", values)
+			return nil
+		}})
+	}
+	if len(o.partitionMins) > 0 {
+		checks = append(checks, readycheck.Check{Name: "partition counts", Objects: partitionTables(o.partitionMins), Run: func(ctx context.Context, conn *pgx.Conn) error {
+			short, err := checkPartitionCounts(ctx, conn, o.partitionMins, o.defaultSchema)
+			if err != nil {
+				return err
+			}
+			if len(short) > 0 {
+				return readycheck.ObjectsNotReady("not enough partitions", short)
+			}
+			slog.Debug("All partition counts met", "partition_counts", o.partitionCounts)
+			return nil
+		}})
+	}
+	if len(o.bloatLimits) > 0 {
+		checks = append(checks, readycheck.Check{Name: "table bloat", Objects: bloatTables(o.bloatLimits), Privileged: true, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			over, notFound, err := checkTableBloat(ctx, conn, o.bloatLimits, o.defaultSchema)
+			if errors.Is(err, errNoPgstattuple) {
+				// Bloat is a maintenance gate, not a correctness one; don't hold up readiness over it.
+				slog.Warn("Skipping table bloat check", "error", err)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(over) > 0 {
+				if o.bloatFatal {
+					return readycheck.ObjectsMisconfigured("tables over the bloat limit", over)
+				}
+				return readycheck.ObjectsNotReady("tables over the bloat limit", over)
+			}
+			if len(notFound) > 0 {
+				return readycheck.ObjectsNotReady("tables for bloat check missing", readycheck.MissingObjects(notFound, ""))
+			}
+			slog.Debug("All tables within bloat limits", "limits", o.maxBloat)
+			return nil
+		}})
+	}
+	if len(o.eventTriggerSpecs) > 0 {
+		checks = append(checks, readycheck.Check{Name: "event triggers", Objects: eventTriggerNames(o.eventTriggerSpecs), Run: func(ctx context.Context, conn *pgx.Conn) error {
+			wrong, notFound, err := checkEventTriggers(ctx, conn, o.eventTriggerSpecs, o.evtEnabled)
+			if err != nil {
+				return err
+			}
+			if len(wrong) > 0 {
+				// A disabled or rewired DDL audit trigger is a regression, not a pending migration.
+				return readycheck.ObjectsMisconfigured("event triggers not as expected", append(wrong, readycheck.MissingObjects(notFound, "event trigger missing")...))
+			}
+			if len(notFound) > 0 {
+				return readycheck.ObjectsNotReady("required event triggers missing", readycheck.MissingObjects(notFound, ""))
+			}
+			slog.Debug("All required event triggers found", "event_triggers", o.eventTriggers)
+			return nil
+		}})
+	}
+	if len(o.absentRowFilters) > 0 {
+		checks = append(checks, readycheck.Check{Name: "rows absent", Objects: rowFilterNames(o.absentRowFilters), Run: func(ctx context.Context, conn *pgx.Conn) error {
+			present, err := checkRowsAbsent(ctx, conn, o.absentRowFilters, o.defaultSchema)
+			if err != nil {
+				return err
+			}
+			if len(present) > 0 {
+				return readycheck.ObjectsNotReady("rows that should be absent still present", readycheck.MissingObjects(present, ""))
+			}
+			slog.Debug("All rows absent", "rows", o.rowsAbsent)
+			return nil
+		}})
+	}
+	if len(requiredExts) > 0 {
+		checks = append(checks, readycheck.Check{Name: "extensions", Objects: requiredExts, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			missing, err := checkExtensionsExist(ctx, conn, requiredExts)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return readycheck.ObjectsNotReady("required extensions missing", readycheck.MissingObjects(missing, ""))
+			}
+			slog.Debug("All required extensions installed", "extensions", o.extensions)
+			return nil
+		}})
+	}
+	if len(requiredAvailableExts) > 0 {
+		checks = append(checks, readycheck.Check{Name: "available extensions", Objects: requiredAvailableExts, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			unavailable, err := checkExtensionsAvailable(ctx, conn, requiredAvailableExts)
+			if err != nil {
+				return err
+			}
+			if len(unavailable) > 0 {
+				// The extension's files aren't on the server; no migration can fix that.
+				return readycheck.ObjectsMisconfigured("extensions not available for installation on the server", readycheck.MissingObjects(unavailable, ""))
+			}
+			slog.Debug("All required extensions are available", "extensions", o.availableExts)
+			return nil
+		}})
+	}
+	if len(requiredFDWServers) > 0 {
+		checks = append(checks, readycheck.Check{Name: "foreign servers", Objects: requiredFDWServers, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			problems, err := checkForeignServers(ctx, conn, requiredFDWServers, o.requireMapping)
+			if err != nil {
+				return err
+			}
+			if len(problems) > 0 {
+				return readycheck.ObjectsNotReady("foreign servers not ready", problems)
+			}
+			slog.Debug("All required foreign servers found", "servers", o.fdwServers)
+			return nil
+		}})
+	}
+	if len(requiredForeignTables) > 0 {
+		checks = append(checks, readycheck.Check{Name: foreignTablesCheckName, Objects: requiredForeignTables, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			missing, err := checkForeignTablesExist(ctx, conn, requiredForeignTables, o.defaultSchema)
+			if err != nil {
+				return err
+			}
+			if o.tablesAbsent {
+				if present := presentObjects(requiredForeignTables, missing); len(present) > 0 {
+					return readycheck.ObjectsNotReady("foreign tables still present", readycheck.MissingObjects(present, ""))
+				}
+				slog.Debug("All foreign tables absent", "foreign_tables", o.foreignTables)
+				return nil
+			}
+			if len(missing) > 0 {
+				return readycheck.ObjectsNotReady("required foreign tables missing", readycheck.MissingObjects(missing, ""))
+			}
+			slog.Debug("All required foreign tables found", "foreign_tables", o.foreignTables)
+			return nil
+		}})
+	}
+	if len(o.defaultPrivSpecs) > 0 {
+		checks = append(checks, readycheck.Check{Name: "default privileges", Objects: defaultPrivilegeNames(o.defaultPrivSpecs), Run: func(ctx context.Context, conn *pgx.Conn) error {
+			missing, err := checkDefaultPrivileges(ctx, conn, o.defaultPrivSpecs)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				// Default privileges are provisioned up front, not by the migrations we'd be waiting for.
+				return readycheck.ObjectsMisconfigured("default privileges missing", readycheck.MissingObjects(missing, ""))
+			}
+			slog.Debug("All required default privileges found")
+			return nil
+		}})
+	}
+	if o.minMigration != "" {
+		table := foldIdentifiers([]string{o.migrationTable}, o.exactCase)[0]
+		column := foldIdentifiers([]string{o.migrationColumn}, o.exactCase)[0]
+		checks = append(checks, readycheck.Check{Name: "migration version", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			current, ok, err := checkMigrationVersion(ctx, conn, table, column, o.minMigration, o.defaultSchema, o.migrationOrder == "numeric")
+			if err != nil {
+				return err
+			}
+			if current == "" {
+				return readycheck.NotReady("no migrations applied yet in %s", o.migrationTable)
+			}
+			if !ok {
+				return readycheck.NotReady("latest migration version is %s, need at least %s", current, o.minMigration)
+			}
+			slog.Debug("Migrations applied", "version", current)
+			return nil
+		}})
+	}
+	if o.checkQuery != "" {
+		checks = append(checks, readycheck.Check{Name: "check query", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			ready, err := runReadinessQuery(ctx, conn, o.checkQuery, o.checkExpect)
+			if err != nil {
+				return err // Retried: the objects it queries may not exist yet during startup
+			}
+			if !ready {
+				expected := o.checkExpect
+				if expected == "" {
+					expected = "true"
+				}
+				return readycheck.NotReady("check query did not return %s", expected)
+			}
+			slog.Debug("Check query satisfied")
+			return nil
+		}})
+	}
+	if o.requireTimezone != "" {
+		checks = append(checks, readycheck.Check{Name: "timezone", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			actual, err := checkTimezone(ctx, conn, o.requireTimezone)
+			if err != nil {
+				return err
+			}
+			slog.Debug("Server timezone matches", "timezone", actual)
+			return nil
+		}})
+	}
+
+	if o.requireWalLevel != "" {
+		checks = append(checks, readycheck.Check{Name: "wal level", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			actual, err := checkWalLevel(ctx, conn, o.requireWalLevel)
+			if err != nil {
+				return err
+			}
+			slog.Debug("Server wal_level is sufficient", "wal_level", actual)
+			return nil
+		}})
+	}
+	if o.requirePrimary || o.requireReplica {
+		checks = append(checks, readycheck.Check{Name: "recovery state", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			inRecovery, err := checkRecoveryState(ctx, conn)
+			if err != nil {
+				return err
+			}
+			// Retried either way: a promotion or a re-attached replica is exactly what we'd be waiting for.
+			if o.requirePrimary && inRecovery {
+				return readycheck.NotReady("server is in recovery, not a primary")
+			}
+			if o.requireReplica && !inRecovery {
+				return readycheck.NotReady("server is not in recovery, not a replica")
+			}
+			slog.Debug("Server recovery state as required", "in_recovery", inRecovery)
+			return nil
+		}})
+	}
+	if o.maxReplicaLag > 0 {
+		checks = append(checks, readycheck.Check{Name: "replication lag", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			lag, ok, err := checkReplicationLag(ctx, conn)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return readycheck.NotReady("replica hasn't replayed any transaction yet")
+			}
+			if lag > o.maxReplicaLag {
+				return readycheck.NotReady("replication lag is %s, need at most %s", lag.Round(time.Millisecond), o.maxReplicaLag)
+			}
+			slog.Debug("Replication lag is low enough", "lag", lag)
+			return nil
+		}})
+	}
+	if o.requireSync {
+		checks = append(checks, readycheck.Check{Name: "sync standbys", Privileged: true, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			setting, err := checkSyncStandbys(ctx, conn)
+			if err != nil {
+				return err
+			}
+			slog.Debug("Synchronous standbys are syncing", "synchronous_standby_names", setting)
+			return nil
+		}})
+	}
+	if len(o.settingReqs) > 0 {
+		checks = append(checks, readycheck.Check{Name: "settings", Objects: settingNames(o.settingReqs), Run: func(ctx context.Context, conn *pgx.Conn) error {
+			unmet, err := checkSettingRequirements(ctx, conn, o.settingReqs)
+			if err != nil {
+				return err
+			}
+			if len(unmet) > 0 {
+				// Capacity settings need a reload or restart, not something we can wait out.
+				return readycheck.ObjectsMisconfigured("settings requirements not met", unmet)
+			}
+			slog.Debug("All setting requirements met", "settings", o.settingsMin)
+			return nil
+		}})
+	}
+	if o.collVersions {
+		checks = append(checks, readycheck.Check{Name: "collation versions", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			mismatched, err := checkCollationVersions(ctx, conn)
+			if err != nil {
+				return err
+			}
+			// The server works fine meanwhile; it's the text indexes that need a REINDEX, so only warn.
+			for _, m := range mismatched {
+				slog.Warn("Collation version mismatch", "collation", m)
+			}
+			if len(mismatched) == 0 {
+				slog.Debug("No collation version mismatches")
+			}
+			return nil
+		}})
+	}
+	if o.probeWrite {
+		checks = append(checks, readycheck.Check{Name: "temp write probe", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			if err := probeTempWrite(ctx, conn); err != nil {
+				return err
+			}
+			slog.Debug("Temp write probe succeeded")
+			return nil
+		}})
+	}
+	if o.minFreeConns > 0 {
+		checks = append(checks, readycheck.Check{Name: "free connections", Privileged: true, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			return checkFreeConnections(ctx, conn, o.minFreeConns)
+		}})
+	}
+
+	if o.drainApp != "" {
+		checks = append(checks, readycheck.Check{Name: "drain", Privileged: true, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			slots, err := queryConnectionSlots(ctx, conn, o.drainApp)
+			if err != nil {
+				return err
+			}
+			if slots.used > 0 {
+				return readycheck.NotReady("%d connections from application '%s' still open", slots.used, o.drainApp)
+			}
+			slog.Debug("All connections from application have drained", "application", o.drainApp)
+			return nil
+		}})
+	}
+
+	for _, name := range readycheck.DisableChecks(checks, parseTableList(o.skipChecks)) {
+		// Not fatal: a check that isn't configured is as good as skipped.
+		slog.Warn("-skip-checks: no configured check with this name", "check", name)
+	}
+
+	if o.invert {
+		// A check has no meaningful opposite, and can't run on a database that's gone anyway.
+		for _, c := range checks {
+			if !c.Disabled {
+				return nil, fmt.Errorf("-invert: only waits for the database to stop accepting connections, can't be combined with the %s check", c.Name)
+			}
+		}
+	}
+	return checks, nil
+}
+
+// tableCheckQueryParams is the data a -table-check-query template is rendered with.
+// The placeholders become bind parameters, so names are never spliced into the SQL.
+type tableCheckQueryParams struct {
+	Schema string
+	Table  string
+}
+
+// renderTableCheckQuery turns a -table-check-query template into SQL taking the schema as $1
+// and the table as $2. Both placeholders must be used, otherwise the query can't tell tables apart.
+func renderTableCheckQuery(tmpl string) (string, error) {
+	t, err := template.New("table-check-query").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, tableCheckQueryParams{Schema: "$1", Table: "$2"}); err != nil {
+		return "", err
+	}
+	query := sb.String()
+	if !strings.Contains(query, "$1") || !strings.Contains(query, "$2") {
+		return "", errors.New("template must reference both {{.Schema}} and {{.Table}}")
+	}
+	return query, nil
+}
+
+// databaseTables are the tables of a -tables entry for a database other than the connection's.
+type databaseTables struct {
+	database string
+	tables   []string
+}
+
+// parseTablesByDatabase parses -tables: comma-separated tables, or semicolon-separated groups of
+// them, each prefixed with the database they're in ("db1:users,orders;db2:events"). Tables
+// without a database are in the one connected to. Returns those, then the other groups in order.
+func parseTablesByDatabase(value string) ([]string, []databaseTables, error) {
+	var mainTables []string
+	var others []databaseTables
+	for _, entry := range strings.Split(value, ";") {
+		database, list, found := strings.Cut(entry, ":")
+		if !found {
+			mainTables = appendNew(mainTables, parseTableList(entry)...)
+			continue
+		}
+		database = strings.TrimSpace(database)
+		tables := parseTableList(list)
+		if database == "" || len(tables) == 0 {
+			return nil, nil, fmt.Errorf("'%s' is not of the form database:table[,table...]", strings.TrimSpace(entry))
+		}
+		if i := slices.IndexFunc(others, func(o databaseTables) bool { return o.database == database }); i >= 0 {
+			others[i].tables = appendNew(others[i].tables, tables...)
+			continue
+		}
+		others = append(others, databaseTables{database: database, tables: tables})
+	}
+	return mainTables, others, nil
+}
+
+// connectDatabase opens a connection to another database of the same server, with config's
+// credentials and other settings, for the objects that live there.
+func connectDatabase(ctx context.Context, config *pgx.ConnConfig, database string) (*pgx.Conn, error) {
+	cfg := config.Copy()
+	cfg.Database = database
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database '%s': %w", database, readycheck.SanitizeError(err, cfg.Password))
+	}
+	return conn, nil
+}
+
+// checkTablesNonEmpty checks that each (existing) table has at least one row. The names are
+// quoted as identifiers, so they can't inject SQL. Returns the empty tables, in input order.
+func checkTablesNonEmpty(ctx context.Context, conn *pgx.Conn, tables []string, defaultSchema string) ([]string, error) {
+	empty := []string{}
+	for _, table := range tables {
+		var hasRows bool
+		if err := conn.QueryRow(ctx, nonEmptyQuery(table, defaultSchema)).Scan(&hasRows); err != nil {
+			return nil, fmt.Errorf("error querying rows of table '%s': %w", table, err)
+		}
+		if !hasRows {
+			empty = append(empty, table)
+		}
+	}
+	return empty, nil
+}
+
+// nonEmptyQuery returns the query for whether the table has a row, with its name quoted.
+func nonEmptyQuery(table, defaultSchema string) string {
+	schemaName, tableName := readycheck.SplitQualifiedName(table, defaultSchema)
+	return fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s)", pgx.Identifier{schemaName, tableName}.Sanitize())
+}
+
+// parseColumnLists parses the semicolon-separated table:column[,column...] entries of -columns into
+// the columns each table must have. A table listed twice needs the columns of both entries, each once.
+func parseColumnLists(value string) (map[string][]string, error) {
+	columns := map[string][]string{}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		table, list, ok := strings.Cut(entry, ":")
+		table = strings.TrimSpace(table)
+		names := parseTableList(list)
+		if !ok || table == "" || len(names) == 0 {
+			return nil, fmt.Errorf("'%s' is not of the form table:column[,column...]", entry)
+		}
+		columns[table] = appendNew(columns[table], names...)
+	}
+	return columns, nil
+}
+
+// foldIdentifiers lower-cases the parts of object names outside double quotes, like PostgreSQL
+// folds unquoted identifiers, so "Users" finds the table created with CREATE TABLE Users. Quoted
+// parts keep their case and their quotes, for SplitQualifiedName to remove. Names that fold to the
+// same one, like users and USERS, are kept once. With exactCase the names are returned as given.
+func foldIdentifiers(names []string, exactCase bool) []string {
+	if exactCase {
+		return names
+	}
+	folded := make([]string, 0, len(names))
+	for _, name := range names {
+		var b strings.Builder
+		quoted := false
+		for _, r := range name {
+			if r == '"' {
+				quoted = !quoted
+			}
+			if !quoted {
+				r = unicode.ToLower(r)
+			}
+			b.WriteRune(r)
+		}
+		folded = appendNew(folded, b.String())
+	}
+	return folded
+}
+
+// unquoteIdentifiers applies readycheck.UnquoteIdentifier to names that can't be schema-qualified,
+// like roles and columns.
+func unquoteIdentifiers(names []string) []string {
+	unquoted := make([]string, len(names))
+	for i, name := range names {
+		unquoted[i] = readycheck.UnquoteIdentifier(name)
+	}
+	return unquoted
+}
+
+// checkColumnsExist checks that each table has the given columns, per information_schema.columns
+// (which only lists the columns the user has some privilege on). Returns the missing columns per
+// table; all of them if the table itself doesn't exist (yet).
+func checkColumnsExist(ctx context.Context, conn *pgx.Conn, columns map[string][]string, defaultSchema string) (map[string][]string, error) {
+	query := `SELECT column_name FROM information_schema.columns
+		WHERE table_schema = $1 AND table_name = $2 AND column_name = ANY($3)`
+
+	missing := map[string][]string{}
+	for table, required := range columns {
+		schemaName, tableName := readycheck.SplitQualifiedName(table, defaultSchema)
+		rows, err := conn.Query(ctx, query, schemaName, tableName, required)
+		if err != nil {
+			return nil, fmt.Errorf("error querying columns of table '%s': %w", table, err)
+		}
+		found, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return nil, fmt.Errorf("error querying columns of table '%s': %w", table, err)
+		}
+		for _, column := range required {
+			if !slices.Contains(found, column) {
+				missing[table] = append(missing[table], column)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// columnProblems turns the missing columns per table into one problem per table, sorted by table.
+func columnProblems(missing map[string][]string) []readycheck.ObjectProblem {
+	problems := []readycheck.ObjectProblem{}
+	for _, table := range slices.Sorted(maps.Keys(missing)) {
+		problems = append(problems, readycheck.ObjectProblem{Name: table, Detail: "missing columns " + strings.Join(missing[table], ", ")})
+	}
+	return problems
+}
+
+// checkViewsExist checks that each view or materialized view exists. Unlike information_schema.tables,
+// pg_class lists materialized views too. With requirePopulated, materialized views must also have
+// been populated (i.e. not be WITH NO DATA or awaiting their first REFRESH). Returns the problems,
+// in input order.
+func checkViewsExist(ctx context.Context, conn *pgx.Conn, views []string, defaultSchema string, requirePopulated bool) ([]readycheck.ObjectProblem, error) {
+	query := `SELECT c.relkind::text, c.relispopulated
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2 AND c.relkind IN ('v', 'm')`
+
+	problems := []readycheck.ObjectProblem{}
+	for _, view := range views {
+		schemaName, viewName := readycheck.SplitQualifiedName(view, defaultSchema)
+
+		var relkind string
+		var populated bool
+		err := conn.QueryRow(ctx, query, schemaName, viewName).Scan(&relkind, &populated)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				problems = append(problems, readycheck.ObjectProblem{Name: view, Detail: "view missing"})
+				continue
+			}
+			return nil, fmt.Errorf("error querying for view '%s': %w", view, err)
+		}
+		if requirePopulated && relkind == "m" && !populated {
+			problems = append(problems, readycheck.ObjectProblem{Name: view, Detail: "not populated"})
+		}
+	}
+	return problems, nil
+}
+
+// checkIndexesExist checks that each index exists, including partitioned indexes. Index names
+// are only unique within a schema, so the (default) schema is part of the lookup.
+// Returns the missing indexes, in input order.
+func checkIndexesExist(ctx context.Context, conn *pgx.Conn, indexes []string, defaultSchema string) ([]string, error) {
+	query := `SELECT 1
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2 AND c.relkind IN ('i', 'I')`
+
+	missing := []string{}
+	for _, index := range indexes {
+		schemaName, indexName := readycheck.SplitQualifiedName(index, defaultSchema)
+
+		var one int
+		err := conn.QueryRow(ctx, query, schemaName, indexName).Scan(&one)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				missing = append(missing, index)
+				continue
+			}
+			return nil, fmt.Errorf("error querying for index '%s': %w", index, err)
+		}
+	}
+	return missing, nil
+}
+
+// checkRolesExist checks that each role (user or group) exists in pg_roles, in one query.
+// Role names are global to the server, so they aren't schema-qualified. Returns the missing ones,
+// in input order. Not being allowed to read pg_roles is reported with readycheck.CannotCheck,
+// since retrying won't help and it says nothing about whether the roles exist.
+func checkRolesExist(ctx context.Context, conn *pgx.Conn, roles []string) ([]string, error) {
+	rows, err := conn.Query(ctx, `SELECT rolname::text FROM pg_roles WHERE rolname = ANY($1)`, roles)
+	if err != nil {
+		return nil, rolesQueryError(err)
+	}
+	found, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, rolesQueryError(err)
+	}
+
+	missing := []string{}
+	for _, role := range roles {
+		if !slices.Contains(found, role) {
+			missing = append(missing, role)
+		}
+	}
+	return missing, nil
+}
+
+// rolesQueryError wraps a failed pg_roles query, turning insufficient_privilege into CannotCheck.
+func rolesQueryError(err error) error {
+	err = fmt.Errorf("error querying for roles: %w", err)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42501" {
+		return readycheck.CannotCheck(err)
+	}
+	return err
+}
+
+// waitForNotification listens on channel and blocks until a notification arrives on it, or ctx
+// is done. A NOTIFY sent before the LISTEN is lost, as always with PostgreSQL notifications.
+func waitForNotification(ctx context.Context, conn *pgx.Conn, channel string) error {
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return fmt.Errorf("error listening on channel '%s': %w", channel, err)
+	}
+	notification, err := conn.WaitForNotification(ctx)
+	if err != nil {
+		return fmt.Errorf("error waiting for a notification on channel '%s': %w", channel, err)
+	}
+	slog.Debug("Notification received", "channel", notification.Channel, "payload", notification.Payload)
+	return nil
+}
+
+// presentObjects returns the objects that aren't in missing, in order. It turns an existence
+// check's result around for -absent.
+func presentObjects(objects, missing []string) []string {
+	present := []string{}
+	for _, o := range objects {
+		if !slices.Contains(missing, o) {
+			present = append(present, o)
+		}
+	}
+	return present
+}
+
+// checkTypesExist checks that each type exists in pg_type with the given typtype: 'c' for
+// standalone composite types (CREATE TYPE ... AS, not the row types of tables) or 'd' for domains.
+// Names are schema-qualified like tables. Returns the missing ones, in input order.
+func checkTypesExist(ctx context.Context, conn *pgx.Conn, types []string, defaultSchema, typtype string) ([]string, error) {
+	query := `SELECT 1
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		LEFT JOIN pg_class c ON c.oid = t.typrelid
+		WHERE n.nspname = $1 AND t.typname = $2 AND t.typtype = $3
+		  AND (t.typtype <> 'c' OR c.relkind = 'c')`
+
+	missing := []string{}
+	for _, typ := range types {
+		schemaName, typeName := readycheck.SplitQualifiedName(typ, defaultSchema)
+
+		var one int
+		err := conn.QueryRow(ctx, query, schemaName, typeName, typtype).Scan(&one)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				missing = append(missing, typ)
+				continue
+			}
+			return nil, fmt.Errorf("error querying for type '%s': %w", typ, err)
+		}
+	}
+	return missing, nil
+}
+
+// checkRowLevelSecurity checks that row-level security is enabled on each table (and forced,
+// i.e. applied to the table owner too, if requireForced is set). Returns the tables lacking it,
+// and separately the ones that don't exist (yet).
+func checkRowLevelSecurity(ctx context.Context, conn *pgx.Conn, tables []string, defaultSchema string, requireForced bool) (lacking []readycheck.ObjectProblem, notFound []string, err error) {
+	query := `SELECT c.relrowsecurity, c.relforcerowsecurity
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2 AND c.relkind IN ('r', 'p')`
+
+	lacking, notFound = []readycheck.ObjectProblem{}, []string{}
+	for _, table := range tables {
+		schemaName, tableName := readycheck.SplitQualifiedName(table, defaultSchema)
+
+		var enabled, forced bool
+		err := conn.QueryRow(ctx, query, schemaName, tableName).Scan(&enabled, &forced)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				notFound = append(notFound, table)
+				continue
+			}
+			return nil, nil, fmt.Errorf("error querying row-level security for table '%s': %w", table, err)
+		}
+
+		switch {
+		case !enabled:
+			lacking = append(lacking, readycheck.ObjectProblem{Name: table})
+		case requireForced && !forced:
+			lacking = append(lacking, readycheck.ObjectProblem{Name: table, Detail: "not forced"})
+		}
+	}
+	return lacking, notFound, nil
+}
+
+// checkTableAccessMethods checks that each table uses the expected table access method
+// (pg_class.relam, e.g. heap or columnar). Returns each mismatch with the actual and expected
+// method, and separately the tables that don't exist (yet).
+func checkTableAccessMethods(ctx context.Context, conn *pgx.Conn, specs []namedValue, defaultSchema string) (mismatched []readycheck.ObjectProblem, notFound []string, err error) {
+	// Partitioned tables may have no access method of their own, hence the outer join.
+	query := `SELECT coalesce(am.amname, '')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_am am ON am.oid = c.relam
+		WHERE n.nspname = $1 AND c.relname = $2 AND c.relkind IN ('r', 'p', 'm')`
+
+	mismatched, notFound = []readycheck.ObjectProblem{}, []string{}
+	for _, spec := range specs {
+		schemaName, tableName := readycheck.SplitQualifiedName(spec.name, defaultSchema)
+
+		var actual string
+		err := conn.QueryRow(ctx, query, schemaName, tableName).Scan(&actual)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				notFound = append(notFound, spec.name)
+				continue
+			}
+			return nil, nil, fmt.Errorf("error querying access method for table '%s': %w", spec.name, err)
+		}
+		if actual != spec.value {
+			if actual == "" {
+				actual = "none"
+			}
+			mismatched = append(mismatched, readycheck.ObjectProblem{Name: spec.name, Detail: fmt.Sprintf("uses %s, expected %s", actual, spec.value)})
+		}
+	}
+	return mismatched, notFound, nil
+}
+
+// partitionMinimum is one -partition-counts entry: a table that needs at least min partitions.
+type partitionMinimum struct {
+	table string
+	min   int
+}
+
+// parsePartitionCounts parses the comma-separated table:N pairs of -partition-counts.
+func parsePartitionCounts(value string) ([]partitionMinimum, error) {
+	pairs, err := parseNamedValues(value, ":")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]partitionMinimum, 0, len(pairs))
+	for _, p := range pairs {
+		min, err := strconv.Atoi(p.value)
+		if err != nil || min < 0 {
+			return nil, fmt.Errorf("partition count for '%s' must be a non-negative integer, got '%s'", p.name, p.value)
+		}
+		result = append(result, partitionMinimum{table: p.name, min: min})
+	}
+	return result, nil
+}
+
+// partitionTables returns the tables named in the minimums, in order.
+func partitionTables(mins []partitionMinimum) []string {
+	tables := make([]string, len(mins))
+	for i, m := range mins {
+		tables[i] = m.table
+	}
+	return tables
+}
+
+// checkPartitionCounts counts the direct child partitions (pg_inherits) of each table. Returns
+// each table below its minimum with its actual count, including tables that don't exist yet.
+func checkPartitionCounts(ctx context.Context, conn *pgx.Conn, mins []partitionMinimum, defaultSchema string) ([]readycheck.ObjectProblem, error) {
+	query := `SELECT (SELECT count(*)::int FROM pg_inherits i WHERE i.inhparent = c.oid)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2 AND c.relkind IN ('r', 'p')`
+
+	short := []readycheck.ObjectProblem{}
+	for _, m := range mins {
+		schemaName, tableName := readycheck.SplitQualifiedName(m.table, defaultSchema)
+
+		var count int
+		err := conn.QueryRow(ctx, query, schemaName, tableName).Scan(&count)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				short = append(short, readycheck.ObjectProblem{Name: m.table, Detail: "table missing"})
+				continue
+			}
+			return nil, fmt.Errorf("error counting partitions of table '%s': %w", m.table, err)
+		}
+		if count < m.min {
+			short = append(short, readycheck.ObjectProblem{Name: m.table, Detail: fmt.Sprintf("has %d, need %d", count, m.min)})
+		}
+	}
+	return short, nil
+}
+
+// sequenceMinimum is one -sequences entry: a sequence whose last value must be at least min.
+type sequenceMinimum struct {
+	sequence string
+	min      int64
+}
+
+// parseSequenceMinimums parses the comma-separated sequence:N pairs of -sequences.
+func parseSequenceMinimums(value string) ([]sequenceMinimum, error) {
+	pairs, err := parseNamedValues(value, ":")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]sequenceMinimum, 0, len(pairs))
+	for _, p := range pairs {
+		min, err := strconv.ParseInt(p.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("minimum value for '%s' must be an integer, got '%s'", p.name, p.value)
+		}
+		result = append(result, sequenceMinimum{sequence: p.name, min: min})
+	}
+	return result, nil
+}
+
+// sequenceNames returns the sequences named in the minimums, in order.
+func sequenceNames(mins []sequenceMinimum) []string {
+	names := make([]string, len(mins))
+	for i, m := range mins {
+		names[i] = m.sequence
+	}
+	return names
+}
+
+// checkSequences reads the last value of each sequence, as set by nextval or setval (or its start
+// value if neither has run). Returns the value of every sequence found, by name, and each one
+// below its minimum, including sequences that don't exist yet. Reading a sequence needs the
+// SELECT or USAGE privilege on it; without, the check can't run (see CannotCheck).
+func checkSequences(ctx context.Context, conn *pgx.Conn, mins []sequenceMinimum, defaultSchema string) (map[string]int64, []readycheck.ObjectProblem, error) {
+	values := map[string]int64{}
+	short := []readycheck.ObjectProblem{}
+	for _, m := range mins {
+		schemaName, seqName := readycheck.SplitQualifiedName(m.sequence, defaultSchema)
+		query := "SELECT last_value FROM " + pgx.Identifier{schemaName, seqName}.Sanitize()
+
+		var last int64
+		if err := conn.QueryRow(ctx, query).Scan(&last); err != nil {
+			var pgErr *pgconn.PgError
+			switch {
+			case errors.As(err, &pgErr) && pgErr.Code == "42P01":
+				short = append(short, readycheck.ObjectProblem{Name: m.sequence, Detail: "sequence missing"})
+				continue
+			case errors.As(err, &pgErr) && pgErr.Code == "42501":
+				return nil, nil, readycheck.CannotCheck(fmt.Errorf("can't read sequence '%s', grant SELECT or USAGE on it (or use -admin-user): %w", m.sequence, err))
+			}
+			return nil, nil, fmt.Errorf("error reading sequence '%s': %w", m.sequence, err)
+		}
+		values[m.sequence] = last
+		if last < m.min {
+			short = append(short, readycheck.ObjectProblem{Name: m.sequence, Detail: fmt.Sprintf("at %d, need %d", last, m.min)})
+		}
+	}
+	return values, short, nil
+}
+
+// checkForeignServers checks that each foreign server exists in pg_foreign_server and, if
+// requireMapping is set, that a user mapping for the current user (or PUBLIC) exists for it.
+// Returns the servers that fail, with the reason, in input order.
+func checkForeignServers(ctx context.Context, conn *pgx.Conn, servers []string, requireMapping bool) ([]readycheck.ObjectProblem, error) {
+	query := `SELECT s.srvname,
+			EXISTS (SELECT 1 FROM pg_user_mappings m
+				WHERE m.srvid = s.oid AND (m.umuser = 0 OR m.usename = current_user))
+		FROM pg_foreign_server s
+		WHERE s.srvname = ANY($1)`
+
+	rows, err := conn.Query(ctx, query, servers)
+	if err != nil {
+		return nil, fmt.Errorf("error querying foreign servers: %w", err)
+	}
+	mapped := map[string]bool{}
+	var name string
+	var hasMapping bool
+	_, err = pgx.ForEachRow(rows, []any{&name, &hasMapping}, func() error {
+		mapped[name] = hasMapping
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying foreign servers: %w", err)
+	}
+
+	problems := []readycheck.ObjectProblem{}
+	for _, server := range servers {
+		hasMapping, found := mapped[server]
+		switch {
+		case !found:
+			problems = append(problems, readycheck.ObjectProblem{Name: server, Detail: "server missing"})
+		case requireMapping && !hasMapping:
+			problems = append(problems, readycheck.ObjectProblem{Name: server, Detail: "no user mapping for current user"})
+		}
+	}
+	return problems, nil
+}
+
+// checkForeignTablesExist checks that each foreign table is listed in information_schema.foreign_tables.
+// Returns the missing ones, in input order.
+func checkForeignTablesExist(ctx context.Context, conn *pgx.Conn, tables []string, defaultSchema string) ([]string, error) {
+	query := `SELECT 1 FROM information_schema.foreign_tables
+		WHERE foreign_table_schema = $1 AND foreign_table_name = $2`
+
+	missing := []string{}
+	for _, table := range tables {
+		schemaName, tableName := readycheck.SplitQualifiedName(table, defaultSchema)
+
+		var one int
+		err := conn.QueryRow(ctx, query, schemaName, tableName).Scan(&one)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				missing = append(missing, table)
+				continue
+			}
+			return nil, fmt.Errorf("error querying for foreign table '%s': %w", table, err)
+		}
+	}
+	return missing, nil
+}
+
+// bloatLimit is one -max-bloat entry: a table whose dead tuples may make up at most maxPercent of it.
+type bloatLimit struct {
+	table      string
+	maxPercent float64
+}
+
+// parseBloatLimits parses the comma-separated table:N% pairs of -max-bloat (the % is optional).
+func parseBloatLimits(value string) ([]bloatLimit, error) {
+	pairs, err := parseNamedValues(value, ":")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]bloatLimit, 0, len(pairs))
+	for _, p := range pairs {
+		max, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(p.value, "%")), 64)
+		if err != nil || max < 0 || max > 100 {
+			return nil, fmt.Errorf("bloat limit for '%s' must be a percentage between 0 and 100, got '%s'", p.name, p.value)
+		}
+		result = append(result, bloatLimit{table: p.name, maxPercent: max})
+	}
+	return result, nil
+}
+
+// bloatTables returns the tables named in the limits, in order.
+func bloatTables(limits []bloatLimit) []string {
+	tables := make([]string, len(limits))
+	for i, l := range limits {
+		tables[i] = l.table
+	}
+	return tables
+}
+
+// errNoPgstattuple means the pgstattuple extension isn't installed in the database.
+var errNoPgstattuple = errors.New("the pgstattuple extension is not installed (CREATE EXTENSION pgstattuple)")
+
+// checkTableBloat estimates the dead tuple percentage of each table with pgstattuple_approx.
+// Returns each table over its limit with the measured bloat, and separately the tables that
+// don't exist (yet). Returns errNoPgstattuple if the extension isn't installed.
+func checkTableBloat(ctx context.Context, conn *pgx.Conn, limits []bloatLimit, defaultSchema string) (over []readycheck.ObjectProblem, notFound []string, err error) {
+	var extSchema string
+	err = conn.QueryRow(ctx, `SELECT n.nspname FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+		WHERE e.extname = 'pgstattuple'`).Scan(&extSchema)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, errNoPgstattuple
+		}
+		return nil, nil, fmt.Errorf("error looking up the pgstattuple extension: %w", err)
+	}
+
+	// The extension may live outside the search_path, so call it schema-qualified.
+	query := fmt.Sprintf(`SELECT s.dead_tuple_percent
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		CROSS JOIN LATERAL %s(c.oid::regclass) s
+		WHERE n.nspname = $1 AND c.relname = $2 AND c.relkind IN ('r', 'm')`,
+		pgx.Identifier{extSchema, "pgstattuple_approx"}.Sanitize())
+
+	over, notFound = []readycheck.ObjectProblem{}, []string{}
+	for _, l := range limits {
+		schemaName, tableName := readycheck.SplitQualifiedName(l.table, defaultSchema)
+
+		var deadPercent float64
+		err := conn.QueryRow(ctx, query, schemaName, tableName).Scan(&deadPercent)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				notFound = append(notFound, l.table)
+				continue
+			}
+			return nil, nil, fmt.Errorf("error measuring bloat of table '%s': %w", l.table, err)
+		}
+		if deadPercent > l.maxPercent {
+			over = append(over, readycheck.ObjectProblem{Name: l.table, Detail: fmt.Sprintf("%.1f%% dead tuples, max %g%%", deadPercent, l.maxPercent)})
+		}
+	}
+	return over, notFound, nil
+}
+
+// eventTriggerSpec is one -event-triggers entry, "name[:event[:function]]". Empty fields aren't checked.
+type eventTriggerSpec struct {
+	name     string
+	event    string // e.g. ddl_command_end
+	function string // Optionally schema-qualified
+}
+
+// parseEventTriggers parses the comma-separated -event-triggers value.
+func parseEventTriggers(value string) ([]eventTriggerSpec, error) {
+	var specs []eventTriggerSpec
+	for _, entry := range parseTableList(value) {
+		parts := strings.Split(entry, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("'%s' is not of the form name[:event[:function]]", entry)
+		}
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		spec := eventTriggerSpec{name: parts[0]}
+		if len(parts) > 1 {
+			spec.event = parts[1]
+		}
+		if len(parts) > 2 {
+			spec.function = parts[2]
+		}
+		if spec.name == "" {
+			return nil, fmt.Errorf("'%s' has an empty event trigger name", entry)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// eventTriggerNames returns the trigger names of the specs, in order.
+func eventTriggerNames(specs []eventTriggerSpec) []string {
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.name
+	}
+	return names
+}
+
+// checkEventTriggers looks up each event trigger in pg_event_trigger. Returns the triggers that
+// exist but are wrong (different event or function, or disabled when requireEnabled is set),
+// and separately the ones that don't exist (yet).
+func checkEventTriggers(ctx context.Context, conn *pgx.Conn, specs []eventTriggerSpec, requireEnabled bool) (wrong []readycheck.ObjectProblem, notFound []string, err error) {
+	query := `SELECT e.evtevent, e.evtenabled::text, p.proname, n.nspname
+		FROM pg_event_trigger e
+		JOIN pg_proc p ON p.oid = e.evtfoid
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE e.evtname = $1`
+
+	wrong, notFound = []readycheck.ObjectProblem{}, []string{}
+	for _, spec := range specs {
+		var event, enabled, funcName, funcSchema string
+		err := conn.QueryRow(ctx, query, spec.name).Scan(&event, &enabled, &funcName, &funcSchema)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				notFound = append(notFound, spec.name)
+				continue
+			}
+			return nil, nil, fmt.Errorf("error querying event trigger '%s': %w", spec.name, err)
+		}
+
+		// The schema is only compared if the spec names one; either part may be quoted.
+		wantSchema, wantFunc := readycheck.SplitQualifiedName(spec.function, "")
+		actualFunc := funcName
+		if wantSchema != "" {
+			actualFunc = funcSchema + "." + funcName
+		}
+		switch {
+		case spec.event != "" && event != spec.event:
+			wrong = append(wrong, readycheck.ObjectProblem{Name: spec.name, Detail: fmt.Sprintf("fires on %s, expected %s", event, spec.event)})
+		case spec.function != "" && (funcName != wantFunc || (wantSchema != "" && funcSchema != wantSchema)):
+			wrong = append(wrong, readycheck.ObjectProblem{Name: spec.name, Detail: fmt.Sprintf("calls %s, expected %s", actualFunc, spec.function)})
+		case requireEnabled && enabled == "D":
+			wrong = append(wrong, readycheck.ObjectProblem{Name: spec.name, Detail: "disabled"})
+		}
+	}
+	return wrong, notFound, nil
+}
+
+// rowFilter is one -rows-absent entry, e.g. "feature_flags:name=legacy_feature,enabled=true":
+// the rows of a table whose columns all equal the given values.
+type rowFilter struct {
+	raw     string // The entry as given, to report it by
+	table   string
+	columns []string
+	values  []string
+}
+
+// parseRowFilters parses the semicolon-separated -rows-absent value.
+func parseRowFilters(value string) ([]rowFilter, error) {
+	var filters []rowFilter
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		table, conditions, ok := strings.Cut(entry, ":")
+		table = strings.TrimSpace(table)
+		if !ok || table == "" {
+			return nil, fmt.Errorf("'%s' is not of the form table:column=value[,column=value...]", entry)
+		}
+		pairs, err := parseNamedValues(conditions, "=")
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", entry, err)
+		}
+		if len(pairs) == 0 {
+			return nil, fmt.Errorf("'%s' has no column=value conditions", entry)
+		}
+		filter := rowFilter{raw: entry, table: table}
+		for _, p := range pairs {
+			filter.columns = append(filter.columns, p.name)
+			filter.values = append(filter.values, p.value)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// rowFilterNames returns the filters as given, in order.
+func rowFilterNames(filters []rowFilter) []string {
+	names := make([]string, len(filters))
+	for i, f := range filters {
+		names[i] = f.raw
+	}
+	return names
+}
+
+// checkRowsAbsent checks that no row matches each filter. Identifiers are quoted and values are
+// sent as bind parameters, compared with the column's text form so any column type works.
+// Returns the filters that still match rows, in input order.
+func checkRowsAbsent(ctx context.Context, conn *pgx.Conn, filters []rowFilter, defaultSchema string) ([]string, error) {
+	present := []string{}
+	for _, f := range filters {
+		schemaName, tableName := readycheck.SplitQualifiedName(f.table, defaultSchema)
+		conditions := make([]string, len(f.columns))
+		args := make([]any, len(f.values))
+		for i, column := range f.columns {
+			conditions[i] = fmt.Sprintf("%s::text = $%d", pgx.Identifier{column}.Sanitize(), i+1)
+			args[i] = f.values[i]
+		}
+		query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s)",
+			pgx.Identifier{schemaName, tableName}.Sanitize(), strings.Join(conditions, " AND "))
+
+		var exists bool
+		if err := conn.QueryRow(ctx, query, args...).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("error querying rows for '%s': %w", f.raw, err)
+		}
+		if exists {
+			present = append(present, f.raw)
+		}
+	}
+	return present, nil
+}
+
+// checkExtensionsExist checks that each extension has been created in the database (pg_extension).
+// Returns the missing ones, in input order.
+func checkExtensionsExist(ctx context.Context, conn *pgx.Conn, extensions []string) ([]string, error) {
+	rows, err := conn.Query(ctx, `SELECT extname FROM pg_extension WHERE extname = ANY($1)`, extensions)
+	if err != nil {
+		return nil, fmt.Errorf("error querying installed extensions: %w", err)
+	}
+	installed, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("error querying installed extensions: %w", err)
+	}
+
+	missing := []string{}
+	for _, ext := range extensions {
+		if !slices.Contains(installed, ext) {
+			missing = append(missing, ext)
+		}
+	}
+	return missing, nil
+}
+
+// checkExtensionsAvailable checks that each extension is installable, i.e. listed in
+// pg_available_extensions (whether or not it has been created). Returns the ones that aren't, in input order.
+func checkExtensionsAvailable(ctx context.Context, conn *pgx.Conn, extensions []string) ([]string, error) {
+	rows, err := conn.Query(ctx, `SELECT name FROM pg_available_extensions WHERE name = ANY($1)`, extensions)
+	if err != nil {
+		return nil, fmt.Errorf("error querying available extensions: %w", err)
+	}
+	available, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("error querying available extensions: %w", err)
+	}
+
+	missing := []string{}
+	for _, ext := range extensions {
+		if !slices.Contains(available, ext) {
+			missing = append(missing, ext)
+		}
+	}
+	return missing, nil
+}
+
+// defaultACLObjectTypes maps the object kinds accepted by -default-privileges to pg_default_acl.defaclobjtype.
+var defaultACLObjectTypes = map[string]string{
+	"tables":    "r",
+	"sequences": "S",
+	"functions": "f",
+	"types":     "T",
+	"schemas":   "n",
+}
+
+// defaultPrivilegeSpec is one expected ALTER DEFAULT PRIVILEGES grant,
+// e.g. "app.tables:reader=SELECT,INSERT".
+type defaultPrivilegeSpec struct {
+	schema     string
+	objectKind string // Key of defaultACLObjectTypes
+	grantee    string // Role name, or PUBLIC
+	privileges []string
+}
+
+// parseDefaultPrivileges parses the semicolon-separated -default-privileges value.
+func parseDefaultPrivileges(value string) ([]defaultPrivilegeSpec, error) {
+	var specs []defaultPrivilegeSpec
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, grant, ok := strings.Cut(entry, ":")
+		schema, kind := readycheck.SplitQualifiedName(strings.TrimSpace(target), "") // The schema may be quoted, e.g. "my.app".tables
+		grantee, privs, ok3 := strings.Cut(grant, "=")
+		if !ok || schema == "" || !ok3 {
+			return nil, fmt.Errorf("'%s' is not of the form schema.kind:grantee=PRIV[,PRIV]", entry)
+		}
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if _, known := defaultACLObjectTypes[kind]; !known {
+			return nil, fmt.Errorf("unknown object kind '%s' in '%s' (use tables, sequences, functions, types or schemas)", kind, entry)
+		}
+		spec := defaultPrivilegeSpec{
+			schema:     strings.TrimSpace(schema),
+			objectKind: kind,
+			grantee:    strings.TrimSpace(grantee),
+		}
+		for _, p := range strings.Split(privs, ",") {
+			if p = strings.ToUpper(strings.TrimSpace(p)); p != "" {
+				spec.privileges = append(spec.privileges, p)
+			}
+		}
+		if spec.schema == "" || spec.grantee == "" || len(spec.privileges) == 0 {
+			return nil, fmt.Errorf("'%s' is not of the form schema.kind:grantee=PRIV[,PRIV]", entry)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// describe names one of the spec's privileges, e.g. "SELECT on tables in schema app to reader".
+func (spec defaultPrivilegeSpec) describe(privilege string) string {
+	return fmt.Sprintf("%s on %s in schema %s to %s", privilege, spec.objectKind, spec.schema, spec.grantee)
+}
+
+// defaultPrivilegeNames describes every privilege the specs expect, in order.
+func defaultPrivilegeNames(specs []defaultPrivilegeSpec) []string {
+	var names []string
+	for _, spec := range specs {
+		for _, priv := range spec.privileges {
+			names = append(names, spec.describe(priv))
+		}
+	}
+	return names
+}
+
+// checkDefaultPrivileges looks up each spec in pg_default_acl and returns a description
+// of every expected privilege that isn't granted by default. Owner-specific and global
+// defaults both count, as long as they apply to the schema.
+func checkDefaultPrivileges(ctx context.Context, conn *pgx.Conn, specs []defaultPrivilegeSpec) ([]string, error) {
+	query := `SELECT DISTINCT a.privilege_type
+		FROM pg_default_acl d
+		JOIN pg_namespace n ON n.oid = d.defaclnamespace
+		CROSS JOIN LATERAL aclexplode(d.defaclacl) a
+		LEFT JOIN pg_roles r ON r.oid = a.grantee
+		WHERE n.nspname = $1 AND d.defaclobjtype = $2
+		  AND CASE WHEN a.grantee = 0 THEN 'PUBLIC' ELSE r.rolname END = $3`
+
+	missing := []string{}
+	for _, spec := range specs {
+		rows, err := conn.Query(ctx, query, spec.schema, defaultACLObjectTypes[spec.objectKind], spec.grantee)
+		if err != nil {
+			return nil, fmt.Errorf("error querying default privileges for schema '%s': %w", spec.schema, err)
+		}
+		granted, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return nil, fmt.Errorf("error querying default privileges for schema '%s': %w", spec.schema, err)
+		}
+
+		for _, priv := range spec.privileges {
+			if !slices.Contains(granted, priv) {
+				missing = append(missing, spec.describe(priv))
+			}
+		}
+	}
+	return missing, nil
+}
+
+// utcAliases are the zone names Postgres accepts that all mean plain UTC.
+var utcAliases = map[string]bool{
+	"utc":           true,
+	"etc/utc":       true,
+	"uct":           true,
+	"etc/uct":       true,
+	"universal":     true,
+	"etc/universal": true,
+	"zulu":          true,
+	"etc/zulu":      true,
+}
+
+// normalizeTimezone lowercases a zone name and maps the UTC aliases onto "utc",
+// so that e.g. "UTC" and "Etc/UTC" compare equal. Postgres zone names are case-insensitive.
+func normalizeTimezone(tz string) string {
+	tz = strings.ToLower(strings.TrimSpace(tz))
+	if utcAliases[tz] {
+		return "utc"
+	}
+	return tz
+}
+
+// checkTimezone compares the server's TimeZone setting to the expected one.
+// Returns the actual timezone; a mismatch is fatal since it won't change at runtime.
+func checkTimezone(ctx context.Context, conn *pgx.Conn, expected string) (string, error) {
+	var actual string
+	if err := conn.QueryRow(ctx, "SELECT current_setting('TimeZone')").Scan(&actual); err != nil {
+		return "", fmt.Errorf("error querying server timezone: %w", err)
+	}
+	if normalizeTimezone(actual) != normalizeTimezone(expected) {
+		return actual, readycheck.Misconfigured("server timezone is '%s', expected '%s'", actual, expected)
+	}
+	return actual, nil
+}
+
+// walLevelRank orders the wal_level values; each level includes everything below it.
+// "archive" and "hot_standby" are the pre-9.6 names for what is now "replica".
+var walLevelRank = map[string]int{
+	"minimal":     0,
+	"archive":     1,
+	"hot_standby": 1,
+	"replica":     1,
+	"logical":     2,
+}
+
+// checkWalLevel verifies the server's wal_level is at least the required level.
+// Returns the actual level; too low a level is fatal since changing it needs a restart.
+func checkWalLevel(ctx context.Context, conn *pgx.Conn, required string) (string, error) {
+	var actual string
+	if err := conn.QueryRow(ctx, "SELECT current_setting('wal_level')").Scan(&actual); err != nil {
+		return "", fmt.Errorf("error querying wal_level: %w", err)
+	}
+	actualRank, ok := walLevelRank[actual]
+	if !ok {
+		return actual, fmt.Errorf("unrecognized wal_level '%s'", actual)
+	}
+	if actualRank < walLevelRank[strings.ToLower(required)] {
+		return actual, readycheck.Misconfigured("server wal_level is '%s', need at least '%s'", actual, required)
+	}
+	return actual, nil
+}
+
+// checkRecoveryState reports whether the server is in recovery, i.e. a replica (or a primary
+// still replaying WAL after a crash) rather than one accepting writes.
+func checkRecoveryState(ctx context.Context, conn *pgx.Conn) (inRecovery bool, err error) {
+	if err := conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		return false, fmt.Errorf("error querying recovery state: %w", err)
+	}
+	return inRecovery, nil
+}
+
+// checkReplicationLag returns how far a replica's replay is behind: the age of the last replayed
+// transaction, or zero once it has replayed all the WAL it received (on an idle primary that age
+// would keep growing while the replica is fully caught up). ok is false while nothing has been
+// replayed yet. A server that isn't in recovery has no lag to speak of and is Misconfigured.
+func checkReplicationLag(ctx context.Context, conn *pgx.Conn) (lag time.Duration, ok bool, err error) {
+	var inRecovery bool
+	var seconds *float64
+	err = conn.QueryRow(ctx, `SELECT pg_is_in_recovery(),
+		CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		     ELSE extract(epoch FROM now() - pg_last_xact_replay_timestamp())::float8 END`).Scan(&inRecovery, &seconds)
+	if err != nil {
+		return 0, false, fmt.Errorf("error querying replication lag: %w", err)
+	}
+	if !inRecovery {
+		return 0, false, readycheck.Misconfigured("server is not in recovery, so it is a primary without replication lag")
+	}
+	if seconds == nil {
+		return 0, false, nil
+	}
+	return time.Duration(max(*seconds, 0) * float64(time.Second)), true, nil
+}
+
+// probeTempWrite creates a temp table and inserts a row into it inside a transaction that is
+// rolled back, to prove the server can actually write (e.g. isn't out of disk). A failed write
+// is reported as not ready, with the server's error, since disk pressure may clear.
+func probeTempWrite(ctx context.Context, conn *pgx.Conn) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting temp write probe: %w", err)
+	}
+	defer tx.Rollback(ctx) // Nothing the probe does should outlive it
+
+	if _, err := tx.Exec(ctx, `CREATE TEMP TABLE pg_ready_check_probe (payload text) ON COMMIT DROP`); err != nil {
+		return readycheck.NotReady("temp write probe failed creating table: %v", err)
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO pg_ready_check_probe VALUES (repeat('x', 1024))`); err != nil {
+		return readycheck.NotReady("temp write probe failed inserting row: %v", err)
+	}
+	return nil
+}
+
+// checkCollationVersions looks for collations (and, on PostgreSQL 15+, the database's default
+// collation) whose recorded version differs from what the OS/ICU library provides now, as happens
+// after a glibc or ICU upgrade. Indexes on text built with the old version may be corrupt.
+// Servers before PostgreSQL 10 don't record collation versions, so nothing is reported there.
+func checkCollationVersions(ctx context.Context, conn *pgx.Conn) ([]readycheck.ObjectProblem, error) {
+	var serverVersion int
+	if err := conn.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&serverVersion); err != nil {
+		return nil, fmt.Errorf("error querying server version: %w", err)
+	}
+	if serverVersion < 100000 {
+		return []readycheck.ObjectProblem{}, nil
+	}
+
+	query := `SELECT 'collation ' || collname, collversion, coalesce(pg_collation_actual_version(oid), '')
+		FROM pg_collation
+		WHERE collversion IS NOT NULL AND collversion IS DISTINCT FROM pg_collation_actual_version(oid)`
+	if serverVersion >= 150000 {
+		query += `
+		UNION ALL
+		SELECT 'database ' || datname, datcollversion, coalesce(pg_database_collation_actual_version(oid), '')
+		FROM pg_database
+		WHERE datname = current_database() AND datcollversion IS NOT NULL
+		  AND datcollversion IS DISTINCT FROM pg_database_collation_actual_version(oid)`
+	}
+
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying collation versions: %w", err)
+	}
+	mismatched := []readycheck.ObjectProblem{}
+	var name, recorded, actual string
+	_, err = pgx.ForEachRow(rows, []any{&name, &recorded, &actual}, func() error {
+		if actual == "" {
+			actual = "unknown"
+		}
+		mismatched = append(mismatched, readycheck.ObjectProblem{Name: name, Detail: fmt.Sprintf("recorded version %s, provider has %s", recorded, actual)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying collation versions: %w", err)
+	}
+	return mismatched, nil
+}
+
+// syncStandbyRequirement is what synchronous_standby_names asks for: num standbys out of names
+// (which may include "*"), either the first ones by priority or any of them (quorum).
+type syncStandbyRequirement struct {
+	quorum bool
+	num    int
+	names  []string
+}
+
+// parseSyncStandbyNames parses a synchronous_standby_names value: "FIRST n (a, b)", "ANY n (a, b)",
+// "n (a, b)" or the pre-9.6 plain list "a, b", which means FIRST 1. Quoted names keep their quotes off.
+func parseSyncStandbyNames(value string) (syncStandbyRequirement, error) {
+	req := syncStandbyRequirement{num: 1}
+	value = strings.TrimSpace(value)
+
+	if method, rest, ok := strings.Cut(value, " "); ok && (strings.EqualFold(method, "FIRST") || strings.EqualFold(method, "ANY")) {
+		req.quorum = strings.EqualFold(method, "ANY")
+		value = strings.TrimSpace(rest)
+	}
+	if open := strings.Index(value, "("); open != -1 {
+		if !strings.HasSuffix(value, ")") {
+			return req, fmt.Errorf("unbalanced parentheses in '%s'", value)
+		}
+		num, err := strconv.Atoi(strings.TrimSpace(value[:open]))
+		if err != nil || num < 1 {
+			return req, fmt.Errorf("invalid number of standbys in '%s'", value)
+		}
+		req.num = num
+		value = value[open+1 : len(value)-1]
+	}
+	for _, name := range parseTableList(value) {
+		req.names = append(req.names, strings.Trim(name, `"`))
+	}
+	if len(req.names) == 0 {
+		return req, errors.New("no standby names")
+	}
+	return req, nil
+}
+
+// matches reports whether a standby's application_name is one of the required names. Like the
+// server, the comparison ignores case.
+func (req syncStandbyRequirement) matches(appName string) bool {
+	return slices.ContainsFunc(req.names, func(name string) bool {
+		return name == "*" || strings.EqualFold(name, appName)
+	})
+}
+
+// checkSyncStandbys verifies that enough of the standbys named in synchronous_standby_names are
+// streaming and synchronous (or quorum candidates) in pg_stat_replication, since otherwise commits
+// on the primary block. Returns the requirement as configured. Named standbys that aren't syncing
+// are reported as not ready; an empty synchronous_standby_names is reported as fatal.
+func checkSyncStandbys(ctx context.Context, conn *pgx.Conn) (string, error) {
+	var setting string
+	if err := conn.QueryRow(ctx, "SELECT current_setting('synchronous_standby_names')").Scan(&setting); err != nil {
+		return "", fmt.Errorf("error querying synchronous_standby_names: %w", err)
+	}
+	if strings.TrimSpace(setting) == "" {
+		return setting, readycheck.Misconfigured("synchronous_standby_names is empty, synchronous replication is not configured")
+	}
+	req, err := parseSyncStandbyNames(setting)
+	if err != nil {
+		return setting, fmt.Errorf("error parsing synchronous_standby_names '%s': %w", setting, err)
+	}
+
+	rows, err := conn.Query(ctx, `SELECT application_name, coalesce(sync_state, '')
+		FROM pg_stat_replication WHERE state = 'streaming'`)
+	if err != nil {
+		return setting, fmt.Errorf("error querying pg_stat_replication: %w", err)
+	}
+	var syncing []string
+	var appName, syncState string
+	_, err = pgx.ForEachRow(rows, []any{&appName, &syncState}, func() error {
+		if (syncState == "sync" || syncState == "quorum") && req.matches(appName) {
+			syncing = append(syncing, appName)
+		}
+		return nil
+	})
+	if err != nil {
+		return setting, fmt.Errorf("error querying pg_stat_replication: %w", err)
+	}
+	if len(syncing) >= req.num {
+		return setting, nil
+	}
+
+	missing := []string{}
+	for _, name := range req.names {
+		if name != "*" && !slices.ContainsFunc(syncing, func(s string) bool { return strings.EqualFold(s, name) }) {
+			missing = append(missing, name)
+		}
+	}
+	summary := fmt.Sprintf("only %d of %d synchronous standbys syncing (synchronous_standby_names '%s')", len(syncing), req.num, setting)
+	if len(missing) == 0 {
+		return setting, readycheck.NotReady("%s", summary)
+	}
+	return setting, readycheck.ObjectsNotReady(summary+", not syncing", readycheck.MissingObjects(missing, ""))
+}
+
+// runReadinessQuery runs an arbitrary query and reports whether it says the database is ready:
+// the first column of the first row must be true, or equal to expected (compared as text) if that
+// isn't empty. No row or NULL means not ready. An error means the query itself failed.
+func runReadinessQuery(ctx context.Context, conn *pgx.Conn, query, expected string) (bool, error) {
+	// The simple protocol returns every value as text, whatever its type, to compare against.
+	rows, err := conn.Query(ctx, query, pgx.QueryExecModeSimpleProtocol)
+	if err != nil {
+		return false, fmt.Errorf("error running check query: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return false, fmt.Errorf("error running check query: %w", err)
+		}
+		return false, nil
+	}
+	raw := rows.RawValues()
+	if len(raw) == 0 || raw[0] == nil {
+		return false, nil
+	}
+	value := string(raw[0])
+	if rows.FieldDescriptions()[0].DataTypeOID == pgtype.BoolOID {
+		// Booleans come back as "t" or "f"
+		value = strconv.FormatBool(value == "t")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("error running check query: %w", err)
+	}
+
+	if expected == "" {
+		return value == "true", nil
+	}
+	return value == expected, nil
+}
+
+// checkMigrationVersion reads the latest applied migration, the largest value in column of table,
+// and reports whether it is at least min. With numeric, versions compare as numbers (20241015 or
+// 42, even stored as text like Rails does); otherwise as strings, byte by byte, for versions like
+// '2024-10-15T12:00'. A table that doesn't exist (yet) or is empty means no migration has run.
+func checkMigrationVersion(ctx context.Context, conn *pgx.Conn, table, column, min, defaultSchema string, numeric bool) (current string, ok bool, err error) {
+	schemaName, tableName := readycheck.SplitQualifiedName(table, defaultSchema)
+	col := pgx.Identifier{readycheck.UnquoteIdentifier(column)}.Sanitize()
+	query := fmt.Sprintf(`SELECT max(%[1]s::text COLLATE "C"), coalesce(max(%[1]s::text COLLATE "C") >= $1, false) FROM %[2]s`,
+		col, pgx.Identifier{schemaName, tableName}.Sanitize())
+	if numeric {
+		query = fmt.Sprintf(`SELECT max(%[1]s::numeric)::text, coalesce(max(%[1]s::numeric) >= $1::numeric, false) FROM %[2]s`,
+			col, pgx.Identifier{schemaName, tableName}.Sanitize())
+	}
+
+	var latest *string
+	if err := conn.QueryRow(ctx, query, min).Scan(&latest, &ok); err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == "42P01":
+			return "", false, nil // undefined_table: the migrations haven't started
+		case errors.As(err, &pgErr) && pgErr.Code == "22P02":
+			return "", false, readycheck.Misconfigured("migration versions in %s aren't numbers, compare them as text instead: %v", table, err)
+		}
+		return "", false, fmt.Errorf("error querying migration version: %w", err)
+	}
+	if latest == nil {
+		return "", false, nil
+	}
+	return *latest, ok, nil
+}
+
+// checkLongLocks finds the tables with a lock held longer than maxAge by another session, e.g. a
+// forgotten transaction that would block a migration's ALTER TABLE. Any lock mode counts, since
+// ACCESS EXCLUSIVE conflicts with all of them. PostgreSQL doesn't record when a lock was granted,
+// so its age is that of the holding transaction. Without pg_read_all_stats the transactions of
+// other users hide their start, and a prepared transaction has no session; such locks count as
+// long-held, so a restricted view waits for the table to be free rather than miss one.
+// Tables that don't exist hold no locks.
+func checkLongLocks(ctx context.Context, conn *pgx.Conn, tables []string, defaultSchema string, maxAge time.Duration) ([]readycheck.ObjectProblem, error) {
+	schemas := make([]string, len(tables))
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		schemas[i], names[i] = readycheck.SplitQualifiedName(table, defaultSchema)
+	}
+
+	rows, err := conn.Query(ctx, `SELECT wanted.ord, l.mode, l.pid, extract(epoch FROM now() - a.xact_start)::float8
+		FROM unnest($1::text[], $2::text[]) WITH ORDINALITY AS wanted(schema_name, table_name, ord)
+		JOIN pg_namespace n ON n.nspname = wanted.schema_name
+		JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = wanted.table_name
+		JOIN pg_locks l ON l.locktype = 'relation' AND l.relation = c.oid AND l.granted
+		  AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+		LEFT JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.pid IS DISTINCT FROM pg_backend_pid()
+		  AND (a.xact_start IS NULL OR now() - a.xact_start > $3::float8 * interval '1 second')
+		ORDER BY wanted.ord, a.xact_start`, schemas, names, maxAge.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error querying pg_locks: %w", err)
+	}
+	locked := []readycheck.ObjectProblem{}
+	seen := map[int64]bool{}
+	var ord int64
+	var mode string
+	var pid *int32
+	var ageSecs *float64
+	_, err = pgx.ForEachRow(rows, []any{&ord, &mode, &pid, &ageSecs}, func() error {
+		if seen[ord] {
+			return nil // Report the oldest lock of each table
+		}
+		seen[ord] = true
+		detail := fmt.Sprintf("%s held by a prepared transaction", mode)
+		switch {
+		case pid != nil && ageSecs != nil:
+			age := time.Duration(*ageSecs * float64(time.Second)).Round(time.Second)
+			detail = fmt.Sprintf("%s held by pid %d for %s", mode, *pid, age)
+		case pid != nil:
+			detail = fmt.Sprintf("%s held by pid %d for an unknown time", mode, *pid)
+		}
+		locked = append(locked, readycheck.ObjectProblem{Name: tables[ord-1], Detail: detail})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying pg_locks: %w", err)
+	}
+	return locked, nil
+}
+
+// connectionSlots is how the server's connection slots are taken.
+type connectionSlots struct {
+	max               int // max_connections
+	superuserReserved int // superuser_reserved_connections
+	reserved          int // reserved_connections (PostgreSQL 16+, for pg_use_reserved_connections)
+	used              int // Client backends, not counting our own
+}
+
+// free returns the slots left for an ordinary role: neither kind of reserved slot is open to it.
+func (s connectionSlots) free() int {
+	return max(s.max-s.superuserReserved-s.reserved-s.used, 0)
+}
+
+// queryConnectionSlots reads the connection limits and counts the client backends in
+// pg_stat_activity, not counting our own connections (conn and, during a check, all the others
+// readycheck holds). If appName isn't empty, only backends with that application_name are counted.
+// Background workers are listed in pg_stat_activity too but aren't client connections.
+// Without pg_read_all_stats, other users' sessions show a NULL backend_type; those that belong to
+// a user are counted as clients too, so a restricted view overestimates rather than misses them.
+func queryConnectionSlots(ctx context.Context, conn *pgx.Conn, appName string) (connectionSlots, error) {
+	query := `SELECT current_setting('max_connections')::int,
+		       current_setting('superuser_reserved_connections')::int,
+		       coalesce(current_setting('reserved_connections', true), '0')::int,
+		       (SELECT count(*)::int
+		        FROM pg_stat_activity
+		        WHERE (backend_type = 'client backend' OR (backend_type IS NULL AND usesysid IS NOT NULL))
+		          AND pid <> pg_backend_pid()
+		          AND pid <> ALL($2)
+		          AND ($1 = '' OR application_name = $1))`
+
+	own := []int32{}
+	for _, pid := range readycheck.OwnBackendPIDs(ctx) {
+		own = append(own, int32(pid))
+	}
+	var s connectionSlots
+	if err := conn.QueryRow(ctx, query, appName, own).Scan(&s.max, &s.superuserReserved, &s.reserved, &s.used); err != nil {
+		return connectionSlots{}, fmt.Errorf("error querying pg_stat_activity: %w", err)
+	}
+	return s, nil
+}
+
+// checkFreeConnections checks that at least need connection slots are free for an ordinary role
+// (see connectionSlots.free).
+func checkFreeConnections(ctx context.Context, conn *pgx.Conn, need int) error {
+	slots, err := queryConnectionSlots(ctx, conn, "")
+	if err != nil {
+		return err
+	}
+	free := slots.free()
+	if free < need {
+		return readycheck.NotReady("only %d free connection slots, need %d", free, need)
+	}
+	slog.Debug("Free connection slots available", "free", free, "max_connections", slots.max, "used", slots.used)
+	return nil
+}
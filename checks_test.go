@@ -0,0 +1,262 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alchen99/pg_ready_check/readycheck"
+)
+
+func TestConnectionSlotsFree(t *testing.T) {
+	tests := []struct {
+		name  string
+		slots connectionSlots
+		want  int
+	}{
+		{name: "idle server", slots: connectionSlots{max: 100, superuserReserved: 3}, want: 97},
+		{name: "busy", slots: connectionSlots{max: 100, superuserReserved: 3, used: 40}, want: 57},
+		{name: "reserved connections", slots: connectionSlots{max: 100, superuserReserved: 3, reserved: 5, used: 40}, want: 52},
+		{name: "no reserved slots", slots: connectionSlots{max: 20, used: 20}, want: 0},
+		// Superusers in their reserved slots can push the count past the limit
+		{name: "superusers in reserved slots", slots: connectionSlots{max: 100, superuserReserved: 3, used: 99}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.slots.free(); got != tt.want {
+				t.Errorf("%+v.free() = %d, want %d", tt.slots, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildChecks(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "none", want: nil},
+		{
+			name: "in run order",
+			args: []string{"-listen-channel", "migrated", "-tables", "users;db2:events", "-columns", "users:id",
+				"-extensions", "postgis", "-require-primary", "-min-free-connections", "5"},
+			want: []string{"notification", "tables", "tables in db2", "columns", "extensions", "recovery state", "free connections"},
+		},
+		{
+			name: "absent",
+			args: []string{"-absent", "-tables", "legacy", "-foreign-tables", "remote.legacy"},
+			want: []string{"tables absent", "foreign tables absent"},
+		},
+		{
+			name: "migrations and query",
+			args: []string{"-min-migration-version", "20241015120000", "-check-query", "select true", "-require-replica", "-max-replica-lag", "5s"},
+			want: []string{"migration version", "check query", "recovery state", "replication lag"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := parseTestOptions(t, tt.args...)
+			if err != nil {
+				t.Fatalf("parseOptions() error = %v", err)
+			}
+			checks, err := buildChecks(o, nil)
+			if err != nil {
+				t.Fatalf("buildChecks() error = %v", err)
+			}
+			var names []string
+			for _, c := range checks {
+				names = append(names, c.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("checks = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildChecksPrivileged(t *testing.T) {
+	o, err := parseTestOptions(t, "-tables", "users", "-max-lock-age", "1m", "-max-bloat", "users:20%", "-require-sync-standbys", "-drain", "old")
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	checks, err := buildChecks(o, nil)
+	if err != nil {
+		t.Fatalf("buildChecks() error = %v", err)
+	}
+	var privileged []string
+	for _, c := range checks {
+		if c.Privileged {
+			privileged = append(privileged, c.Name)
+		}
+	}
+	if want := []string{"lock age", "table bloat", "sync standbys", "drain"}; !slices.Equal(privileged, want) {
+		t.Errorf("privileged checks = %v, want %v", privileged, want)
+	}
+}
+
+func TestBuildChecksSkipAndInvert(t *testing.T) {
+	o, err := parseTestOptions(t, "-require-primary", "-probe-temp-write", "-skip-checks", "Temp Write Probe,no such check")
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	checks, err := buildChecks(o, nil)
+	if err != nil {
+		t.Fatalf("buildChecks() error = %v", err)
+	}
+	if len(checks) != 2 || checks[0].Disabled || !checks[1].Disabled {
+		t.Errorf("checks = %+v, want the temp write probe disabled", checks)
+	}
+
+	// -invert only waits for the database to be gone; any check left enabled is a mistake.
+	o, err = parseTestOptions(t, "-invert", "-require-primary", "-probe-temp-write", "-skip-checks", "temp write probe")
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	if _, err := buildChecks(o, nil); err == nil || !strings.Contains(err.Error(), "the recovery state check") {
+		t.Errorf("buildChecks() with -invert error = %v, want one about the recovery state check", err)
+	}
+	o, err = parseTestOptions(t, "-invert", "-probe-temp-write", "-skip-checks", "temp write probe")
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	if _, err := buildChecks(o, nil); err != nil {
+		t.Errorf("buildChecks() with -invert and every check skipped error = %v", err)
+	}
+}
+
+func TestNonEmptyQuery(t *testing.T) {
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{"users", `SELECT EXISTS (SELECT 1 FROM "app"."users")`},
+		{"billing.invoices", `SELECT EXISTS (SELECT 1 FROM "billing"."invoices")`},
+		{`"Billing"."Invoices"`, `SELECT EXISTS (SELECT 1 FROM "Billing"."Invoices")`},
+		{`"a.b".c`, `SELECT EXISTS (SELECT 1 FROM "a.b"."c")`},
+		// Whatever the name holds stays inside the quotes
+		{`users; DROP TABLE users`, `SELECT EXISTS (SELECT 1 FROM "app"."users; DROP TABLE users")`},
+		{`"x"";DROP TABLE users;--"`, `SELECT EXISTS (SELECT 1 FROM "app"."x"";DROP TABLE users;--")`},
+	}
+	for _, tt := range tests {
+		if got := nonEmptyQuery(tt.table, "app"); got != tt.want {
+			t.Errorf("nonEmptyQuery(%q) = %s, want %s", tt.table, got, tt.want)
+		}
+	}
+}
+
+func TestParseColumnLists(t *testing.T) {
+	got, err := parseColumnLists(" users:id, email ;orders:total;; users:email,name ;billing.invoices:due")
+	if err != nil {
+		t.Fatalf("parseColumnLists() error = %v", err)
+	}
+	want := map[string][]string{
+		"users":            {"id", "email", "name"},
+		"orders":           {"total"},
+		"billing.invoices": {"due"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("parseColumnLists() = %v, want %v", got, want)
+	}
+	for table, columns := range want {
+		if !slices.Equal(got[table], columns) {
+			t.Errorf("columns of %s = %v, want %v", table, got[table], columns)
+		}
+	}
+
+	if got, err := parseColumnLists(""); err != nil || len(got) != 0 {
+		t.Errorf("parseColumnLists(\"\") = %v, %v; want nothing", got, err)
+	}
+	for _, value := range []string{"users", "users:", ":id", "users: , ", "users:id;orders"} {
+		if _, err := parseColumnLists(value); err == nil {
+			t.Errorf("parseColumnLists(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestRequiredColumns(t *testing.T) {
+	// Folded like PostgreSQL does, quotes kept on the table for SplitQualifiedName and taken off the columns
+	o, err := parseTestOptions(t, "-columns", `Users:ID,"Email";"Orders":Total;users:id`)
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	if !slices.Equal(o.requiredColumns["users"], []string{"id", "Email"}) || !slices.Equal(o.requiredColumns[`"Orders"`], []string{"total"}) {
+		t.Errorf("required columns = %v", o.requiredColumns)
+	}
+
+	problems := columnProblems(map[string][]string{"users": {"id", "email"}, "orders": {"total"}})
+	want := []readycheck.ObjectProblem{{Name: "orders", Detail: "missing columns total"}, {Name: "users", Detail: "missing columns id, email"}}
+	if !slices.Equal(problems, want) {
+		t.Errorf("columnProblems() = %v, want %v", problems, want)
+	}
+}
+
+func TestFoldIdentifiers(t *testing.T) {
+	names := []string{"Users", `"Orders"`, `Billing."Invoices"`, "USERS", `"Users"`, "users"}
+	want := []string{"users", `"Orders"`, `billing."Invoices"`, `"Users"`}
+	if got := foldIdentifiers(names, false); !slices.Equal(got, want) {
+		t.Errorf("foldIdentifiers() = %q, want %q", got, want)
+	}
+	if got := foldIdentifiers(names, true); !slices.Equal(got, names) {
+		t.Errorf("foldIdentifiers() with -exact-case = %q, want them as given", got)
+	}
+
+	// -tables Users,USERS looks the table up once
+	o, err := parseTestOptions(t, "-tables", "Users,USERS,orders")
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	checks, err := buildChecks(o, nil)
+	if err != nil {
+		t.Fatalf("buildChecks() error = %v", err)
+	}
+	if len(checks) != 1 || !slices.Equal(checks[0].Objects, []string{"users", "orders"}) {
+		t.Errorf("checks = %+v, want one tables check for users and orders", checks)
+	}
+}
+
+func TestParseSyncStandbyNames(t *testing.T) {
+	tests := []struct {
+		value string
+		want  syncStandbyRequirement
+	}{
+		{"FIRST 2 (s1, s2, s3)", syncStandbyRequirement{num: 2, names: []string{"s1", "s2", "s3"}}},
+		{"first 1 (s1,s2)", syncStandbyRequirement{num: 1, names: []string{"s1", "s2"}}},
+		{"ANY 2 (s1, s2, s3)", syncStandbyRequirement{quorum: true, num: 2, names: []string{"s1", "s2", "s3"}}},
+		{"any 1(*)", syncStandbyRequirement{quorum: true, num: 1, names: []string{"*"}}},
+		{"3 (s1, s2, s3)", syncStandbyRequirement{num: 3, names: []string{"s1", "s2", "s3"}}},
+		// Before 9.6, a plain list meant the first one connected
+		{"s1, s2", syncStandbyRequirement{num: 1, names: []string{"s1", "s2"}}},
+		{" * ", syncStandbyRequirement{num: 1, names: []string{"*"}}},
+		{`FIRST 1 ("Standby-East", "first")`, syncStandbyRequirement{num: 1, names: []string{"Standby-East", "first"}}},
+		{`"any"`, syncStandbyRequirement{num: 1, names: []string{"any"}}},
+	}
+	for _, tt := range tests {
+		got, err := parseSyncStandbyNames(tt.value)
+		if err != nil {
+			t.Errorf("parseSyncStandbyNames(%q) error = %v", tt.value, err)
+			continue
+		}
+		if got.quorum != tt.want.quorum || got.num != tt.want.num || !slices.Equal(got.names, tt.want.names) {
+			t.Errorf("parseSyncStandbyNames(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "FIRST 2 (s1, s2", "ANY two (s1, s2)", "0 (s1)", "FIRST 1 ()", " , "} {
+		if _, err := parseSyncStandbyNames(value); err == nil {
+			t.Errorf("parseSyncStandbyNames(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestSyncStandbyRequirementMatches(t *testing.T) {
+	req := syncStandbyRequirement{num: 1, names: []string{"Standby-East", "s2"}}
+	for appName, want := range map[string]bool{"standby-east": true, "S2": true, "s3": false, "": false} {
+		if got := req.matches(appName); got != want {
+			t.Errorf("matches(%q) = %v, want %v", appName, got, want)
+		}
+	}
+	if !(syncStandbyRequirement{names: []string{"*"}}).matches("anything") {
+		t.Error(`"*" doesn't match every standby`)
+	}
+}
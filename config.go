@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgservicefile"
+	"github.com/jackc/pgx/v5"
+)
+
+// connFlags are the connection settings from the command line, with their env var defaults.
+type connFlags struct {
+	dsn           string
+	host          string
+	port          int
+	user          string
+	dbname        string
+	tls           tlsOptions // From the -ssl* flags, with the inline CA already parsed
+	appName       string
+	adminUser     string
+	adminPassword string
+	passwordFile  string
+	dialTimeout   time.Duration
+	socksProxy    *url.URL // From -socks5; nil connects directly
+}
+
+// resolvedConfig is what the connection flags resolve to, ready to connect with.
+type resolvedConfig struct {
+	conn  *pgx.ConnConfig
+	admin *pgx.ConnConfig // Nil without -admin-user
+}
+
+// dockerEnvVars are the variables of the official postgres Docker image, which compose files
+// often share with the services waiting for it, by the libpq keyword each stands in for.
+var dockerEnvVars = map[string]string{
+	"host":     "POSTGRES_HOST",
+	"port":     "POSTGRES_PORT",
+	"user":     "POSTGRES_USER",
+	"dbname":   "POSTGRES_DB",
+	"password": "POSTGRES_PASSWORD",
+}
+
+// pgEnvVars are the libpq variables that take precedence over the dockerEnvVars.
+var pgEnvVars = map[string]string{
+	"host":     "PGHOST",
+	"port":     "PGPORT",
+	"user":     "PGUSER",
+	"dbname":   "PGDATABASE",
+	"password": "PGPASSWORD",
+}
+
+// flagKeywords are the libpq keywords of the connection flags, whose variables are the pgEnvVars
+// and dockerEnvVars, by flag name.
+var flagKeywords = map[string]string{
+	"host":     "host",
+	"port":     "port",
+	"username": "user",
+	"dbname":   "dbname",
+}
+
+// dockerEnv returns the POSTGRES_* value for a libpq keyword, unless something that takes
+// precedence sets it: a -dsn, the PGSERVICE entry or the PG* variable. Flags are up to the caller.
+func dockerEnv(withDSN bool, service map[string]string, key string) string {
+	if withDSN || service[key] != "" || os.Getenv(pgEnvVars[key]) != "" {
+		return ""
+	}
+	return os.Getenv(dockerEnvVars[key])
+}
+
+// resolveConfig builds the pgx configs for the app and admin connections without connecting.
+// Only the connection flags actually given, or the POSTGRES_* variables standing in for them,
+// go in the DSN. pgx fills in the rest from the PG* environment variables, PGSERVICE and .pgpass
+// (the password too), like libpq would.
+func resolveConfig(f connFlags) (resolvedConfig, error) {
+	service := serviceSettings()
+	explicit := func(name, value, key string) string {
+		if isFlagSet(name) {
+			return value
+		}
+		return dockerEnv(false, service, key)
+	}
+	host := explicit("host", f.host, "host")
+	if host == "" && os.Getenv("PGHOST") == "" && os.Getenv("PGSERVICE") == "" {
+		host = DefaultHost // Rather than pgx's guess at a socket directory
+	}
+	port := 0 // Left to PGPORT or the service
+	if host != "" || isFlagSet("port") {
+		port = f.port
+	}
+	if dockerPort := explicit("port", "", "port"); dockerPort != "" && !isFlagSet("port") {
+		n, err := strconv.Atoi(dockerPort)
+		if err != nil || n < 1 || n > 65535 {
+			return resolvedConfig{}, fmt.Errorf("invalid POSTGRES_PORT '%s'", dockerPort)
+		}
+		port = n
+	}
+	dsnTLS := tlsOptions{
+		rootCAs:      f.tls.rootCAs,
+		rootCertFile: explicit("sslrootcert", f.tls.rootCertFile, ""),
+		certFile:     explicit("sslcert", f.tls.certFile, ""),
+		keyFile:      explicit("sslkey", f.tls.keyFile, ""),
+	}
+	if isFlagSet("sslmode") || f.tls.rootCAs != nil {
+		dsnTLS.sslMode = f.tls.sslMode
+	}
+
+	var res resolvedConfig
+	var password string // Empty leaves it to PGPASSWORD and .pgpass
+	var err error
+	if f.passwordFile != "" {
+		if password, err = readPasswordFile(f.passwordFile); err != nil {
+			return resolvedConfig{}, err
+		}
+	} else if f.dsn == "" {
+		password = dockerEnv(false, service, "password")
+	}
+	if f.dsn != "" {
+		res.conn, err = parseConnConfig(f.dsn, dsnPassword(f.dsn), f.tls.rootCAs, f.dialTimeout)
+		if err == nil && password != "" {
+			res.conn.Password = password
+		}
+	} else {
+		res.conn, err = buildConnConfig(host, port, explicit("username", f.user, "user"), password, explicit("dbname", f.dbname, "dbname"), dsnTLS, f.dialTimeout)
+	}
+	if err != nil {
+		return resolvedConfig{}, err
+	}
+	if f.adminUser != "" {
+		if f.dsn != "" {
+			// Same server and settings, other credentials
+			res.admin, err = parseConnConfig(f.dsn, dsnPassword(f.dsn), f.tls.rootCAs, f.dialTimeout)
+			if err == nil {
+				res.admin.User, res.admin.Password = f.adminUser, f.adminPassword
+			}
+		} else {
+			res.admin, err = buildConnConfig(host, port, f.adminUser, f.adminPassword, explicit("dbname", f.dbname, "dbname"), dsnTLS, f.dialTimeout)
+		}
+		if err != nil {
+			return resolvedConfig{}, fmt.Errorf("admin connection: %w", err)
+		}
+	}
+
+	// Recognizable in pg_stat_activity; an application_name from the DSN (or PGAPPNAME with it) wins.
+	// pgx doesn't read PGOPTIONS itself, so pass it on like libpq unless the DSN has options.
+	for _, cfg := range []*pgx.ConnConfig{res.conn, res.admin} {
+		if cfg == nil {
+			continue
+		}
+		if f.appName != "" && cfg.RuntimeParams["application_name"] == "" {
+			cfg.RuntimeParams["application_name"] = f.appName
+		}
+		if pgOptions := os.Getenv("PGOPTIONS"); pgOptions != "" && cfg.RuntimeParams["options"] == "" {
+			cfg.RuntimeParams["options"] = pgOptions
+		}
+		if f.socksProxy != nil {
+			useSocksProxy(cfg, f.socksProxy, f.dialTimeout)
+		}
+	}
+	return res, nil
+}
+
+// readPasswordFile reads a password kept alone in a file, like a mounted secret. A trailing
+// newline, which editors and echo add, isn't part of it.
+func readPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("can't read -password-file: %w", err)
+	}
+	password := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	if password == "" {
+		return "", fmt.Errorf("-password-file '%s' is empty", path)
+	}
+	return password, nil
+}
+
+// resolvedSetting is one line of the -validate report.
+type resolvedSetting struct {
+	name   string
+	value  string
+	source string // Where the value came from, see settingSource
+}
+
+// describeConfig lists the resolved connection settings with where each came from.
+func describeConfig(f connFlags, res resolvedConfig) []resolvedSetting {
+	cfg := res.conn
+	service := serviceSettings()
+	source := func(flagName, envName, key string) string {
+		src := settingSource(f.dsn != "", service, flagName, envName, key)
+		if src == "default" && dockerEnv(f.dsn != "", service, key) != "" {
+			return "env " + dockerEnvVars[key]
+		}
+		return src
+	}
+
+	hosts := []string{cfg.Host}
+	for _, fallback := range cfg.Fallbacks {
+		if !slices.Contains(hosts, fallback.Host) {
+			hosts = append(hosts, fallback.Host)
+		}
+	}
+	password := "(none)"
+	passwordSource := "not set"
+	if cfg.Password != "" {
+		password = "[PASSWORD]"
+		passwordSource = source("", "PGPASSWORD", "password")
+		if f.passwordFile != "" {
+			passwordSource = "flag -password-file"
+			if !isFlagSet("password-file") {
+				passwordSource = "env PGPASSWORD_FILE"
+			}
+		} else if passwordSource == "default" {
+			passwordSource = "passfile" // Nothing else sets it
+		}
+	}
+	sslMode := f.tls.effectiveMode()
+	if f.dsn != "" {
+		sslMode = "as in -dsn"
+	}
+
+	settings := []resolvedSetting{
+		{"host", strings.Join(hosts, ","), source("host", "PGHOST", "host")},
+		{"port", strconv.Itoa(int(cfg.Port)), source("port", "PGPORT", "port")},
+		{"user", cfg.User, source("username", "PGUSER", "user")},
+		{"password", password, passwordSource},
+		{"dbname", cfg.Database, source("dbname", "PGDATABASE", "dbname")},
+		{"sslmode", sslMode, source("sslmode", "PGSSLMODE", "sslmode")},
+		{"application_name", cfg.RuntimeParams["application_name"], settingSource(false, nil, "application-name", "PGAPPNAME", "")},
+		{"options", cfg.RuntimeParams["options"], settingSource(false, nil, "", "PGOPTIONS", "")},
+	}
+	if res.admin != nil {
+		settings = append(settings, resolvedSetting{"admin user", res.admin.User, "flag -admin-user"})
+	}
+	return settings
+}
+
+// settingSource says where a setting came from. Like libpq, a -dsn wins over a flag (or the -config
+// file, where the flag's own variable isn't set), which wins over a PGSERVICE entry, which wins
+// over the environment. The POSTGRES_* variables, which come after all of these, are left to
+// describeConfig. With a -dsn we can't tell what the DSN itself leaves out, so those are put down
+// to either. Empty names mean there is no such source.
+func settingSource(withDSN bool, service map[string]string, flagName, envName, key string) string {
+	switch {
+	case withDSN && key != "":
+		return "-dsn or environment"
+	case flagName != "" && configFileFlags[flagName]:
+		return "config file"
+	case flagName != "" && isFlagSet(flagName):
+		return "flag -" + flagName
+	case key != "" && service[key] != "":
+		return "service " + os.Getenv("PGSERVICE")
+	case envName != "" && os.Getenv(envName) != "":
+		return "env " + envName
+	default:
+		return "default"
+	}
+}
+
+// serviceSettings reads the PGSERVICE entry from the service file, like pgx does when connecting.
+// Returns nil if there is no service, or it can't be read (pgx reports that when parsing).
+func serviceSettings() map[string]string {
+	name := os.Getenv("PGSERVICE")
+	if name == "" {
+		return nil
+	}
+	path := os.Getenv("PGSERVICEFILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, ".pg_service.conf")
+	}
+	file, err := pgservicefile.ReadServicefile(path)
+	if err != nil {
+		return nil
+	}
+	service, err := file.GetService(name)
+	if err != nil {
+		return nil
+	}
+	return service.Settings
+}
+
+// printSettings writes the -validate report, one "name = value (source)" line per setting.
+func printSettings(w io.Writer, settings []resolvedSetting) error {
+	width := 0
+	for _, s := range settings {
+		width = max(width, len(s.name))
+	}
+	for _, s := range settings {
+		if _, err := fmt.Fprintf(w, "%-*s = %s (%s)\n", width, s.name, s.value, s.source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// flagEnvVars are the environment variables that set a flag's default, by flag name, besides those
+// of the connection flags in flagKeywords.
+var flagEnvVars = map[string]string{
+	"dsn":                "DATABASE_URL",
+	"application-name":   "PGAPPNAME",
+	"conn-timeout":       "PGCONNECT_TIMEOUT",
+	"sslmode":            "PGSSLMODE",
+	"sslrootcert":        "PGSSLROOTCERT",
+	"sslcert":            "PGSSLCERT",
+	"sslkey":             "PGSSLKEY",
+	"sslrootcert-inline": "PGSSLROOTCERT_INLINE",
+	"password-file":      "PGPASSWORD_FILE",
+	"admin-password":     "PG_READY_ADMIN_PASSWORD",
+	"socks5":             "ALL_PROXY",
+}
+
+// envSetsFlag reports whether the environment sets the flag's default: its variable in
+// flagEnvVars, the PG* or POSTGRES_* one of a connection flag, or for -schema a search_path in
+// PGOPTIONS. A value in the -config file doesn't override them, so the environment can adjust a
+// shared file.
+func envSetsFlag(name string) bool {
+	if name == "schema" {
+		return searchPathSchema(os.Getenv("PGOPTIONS")) != ""
+	}
+	vars := []string{flagEnvVars[name]}
+	if key := flagKeywords[name]; key != "" {
+		vars = append(vars, pgEnvVars[key], dockerEnvVars[key])
+	}
+	for _, env := range vars {
+		if env != "" && os.Getenv(env) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// listSeparators are the flags whose lists are separated by something other than commas, for
+// when the config file gives them as a YAML list.
+var listSeparators = map[string]string{
+	"columns":            ";",
+	"rows-absent":        ";",
+	"default-privileges": ";",
+}
+
+// configFileFlags are the flags set from the -config file rather than the command line.
+var configFileFlags = map[string]bool{}
+
+// fileConfig is a -config file: flag values by flag name, as they'd be given on the command line.
+type fileConfig struct {
+	path   string
+	values map[string]string
+}
+
+// loadConfigFile reads a YAML config file mapping flag names, without the dash, to their values:
+//
+//	tables: [users, orders]
+//	timeout: 2m
+//	require-primary: true
+//
+// A list stands for the flag's comma-separated (or, for -columns and friends, semicolon-separated)
+// value. Whether the keys are flags is up to apply.
+func loadConfigFile(path string) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileConfig{}, err
+	}
+	var raw map[string]any
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&raw); err != nil && !errors.Is(err, io.EOF) {
+		return fileConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	cfg := fileConfig{path: path, values: make(map[string]string, len(raw))}
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			return fileConfig{}, fmt.Errorf("%s: no value for '%s'", path, key)
+		case map[string]any:
+			return fileConfig{}, fmt.Errorf("%s: '%s' must be a single value or a list", path, key)
+		case []any:
+			parts := make([]string, len(v))
+			for i, part := range v {
+				parts[i] = fmt.Sprint(part)
+			}
+			separator := listSeparators[key]
+			if separator == "" {
+				separator = ","
+			}
+			cfg.values[key] = strings.Join(parts, separator)
+		default:
+			cfg.values[key] = fmt.Sprint(v)
+		}
+	}
+	return cfg, nil
+}
+
+// apply sets the flags in fs from the file, except those given on the command line or through
+// the environment (see envSetsFlag): defaults < file < environment < flags. Keys that aren't flags
+// are an error, and so is -config itself, so typos don't go unnoticed.
+func (c fileConfig) apply(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting '%s'", c.path, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names) // Report the same bad value first every time
+	for _, name := range names {
+		if given[name] || envSetsFlag(name) {
+			continue
+		}
+		if err := fs.Set(name, c.values[name]); err != nil {
+			return fmt.Errorf("invalid value '%s' for '%s': %w", c.values[name], name, err)
+		}
+		configFileFlags[name] = true
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a -config file for a test and returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pg_ready_check.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+host: db
+port: 5433
+tables: [users, billing.invoices]
+columns: ["users:id,email", "orders:total"]
+require-primary: true
+timeout: 2m
+`)
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("loadConfigFile() error = %v", err)
+	}
+	want := map[string]string{
+		"host":            "db",
+		"port":            "5433",
+		"tables":          "users,billing.invoices",
+		"columns":         "users:id,email;orders:total",
+		"require-primary": "true",
+		"timeout":         "2m",
+	}
+	if !maps.Equal(cfg.values, want) {
+		t.Errorf("values = %v, want %v", cfg.values, want)
+	}
+
+	empty, err := loadConfigFile(writeConfigFile(t, ""))
+	if err != nil || len(empty.values) != 0 {
+		t.Errorf("empty file: loadConfigFile() = %v, %v; want no values", empty.values, err)
+	}
+
+	for content, wantErr := range map[string]string{
+		"host:\n":                 "no value for 'host'",
+		"host: {name: db}\n":      "must be a single value or a list",
+		"host: [db\n":             "yaml",
+		"- just\n- a list\n":      "yaml",
+		"tables: users\n\tbad\n":  "yaml",
+		"timeout: 2m\ntimeout: 1": "yaml",
+	} {
+		if _, err := loadConfigFile(writeConfigFile(t, content)); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("loadConfigFile(%q) error = %v, want one mentioning %q", content, err, wantErr)
+		}
+	}
+	if _, err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("missing file: error = %v, want not exist", err)
+	}
+}
+
+// testFlagSet returns a flag set with some of the real flags, for applying a config file to.
+func testFlagSet() (fs *flag.FlagSet, host *string, schema *string, timeout *time.Duration) {
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	host = fs.String("host", "localhost", "")
+	schema = fs.String("schema", "public", "")
+	timeout = fs.Duration("timeout", time.Minute, "")
+	fs.String("tables", "", "")
+	return fs, host, schema, timeout
+}
+
+func TestFileConfigApply(t *testing.T) {
+	for _, env := range []string{"PGHOST", "POSTGRES_HOST", "PGOPTIONS", "PGSERVICE"} {
+		t.Setenv(env, "")
+	}
+	path := writeConfigFile(t, "host: filehost\nschema: fileschema\ntimeout: 2m\n")
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		wantHost   string
+		wantSchema string
+	}{
+		{name: "file", wantHost: "filehost", wantSchema: "fileschema"},
+		{name: "flag", args: []string{"-host=flaghost"}, wantHost: "flaghost", wantSchema: "fileschema"},
+		{name: "PGHOST", env: map[string]string{"PGHOST": "envhost"}, wantHost: "localhost", wantSchema: "fileschema"},
+		{name: "POSTGRES_HOST", env: map[string]string{"POSTGRES_HOST": "dockerhost"}, wantHost: "localhost", wantSchema: "fileschema"},
+		{name: "flag over env", args: []string{"-host=flaghost"}, env: map[string]string{"PGHOST": "envhost"}, wantHost: "flaghost", wantSchema: "fileschema"},
+		{name: "PGOPTIONS search_path", env: map[string]string{"PGOPTIONS": "-c search_path=app"}, wantHost: "filehost", wantSchema: "public"},
+		{name: "PGOPTIONS without search_path", env: map[string]string{"PGOPTIONS": "-c statement_timeout=5s"}, wantHost: "filehost", wantSchema: "fileschema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clear(configFileFlags)
+			t.Cleanup(func() { clear(configFileFlags) })
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs, host, schema, timeout := testFlagSet()
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := loadConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.apply(fs); err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+			// The values the environment stands for are read later; the flag keeps its default.
+			if *host != tt.wantHost || *schema != tt.wantSchema || *timeout != 2*time.Minute {
+				t.Errorf("host, schema, timeout = %q, %q, %s; want %q, %q, 2m", *host, *schema, *timeout, tt.wantHost, tt.wantSchema)
+			}
+			if configFileFlags["host"] != (tt.wantHost == "filehost") {
+				t.Errorf("configFileFlags[host] = %v", configFileFlags["host"])
+			}
+		})
+	}
+}
+
+func TestFileConfigApplyErrors(t *testing.T) {
+	t.Cleanup(func() { clear(configFileFlags) })
+	for content, wantErr := range map[string]string{
+		"hostname: db\n":         "unknown setting 'hostname'",
+		"config: other.yaml\n":   "unknown setting 'config'",
+		"timeout: soon\n":        "invalid value 'soon' for 'timeout'",
+		"host: db\ntypo: true\n": "unknown setting 'typo'",
+	} {
+		fs, _, _, _ := testFlagSet()
+		fs.String("config", "", "")
+		cfg, err := loadConfigFile(writeConfigFile(t, content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.apply(fs); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("apply(%q) error = %v, want one mentioning %q", content, err, wantErr)
+		}
+	}
+}
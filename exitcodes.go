@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/alchen99/pg_ready_check/readycheck"
+)
+
+// Exit codes
+const (
+	ExitCodeOK            = 0
+	ExitCodeConnFailed    = 1
+	ExitCodeCheckFailed   = 2 // e.g., tables missing
+	ExitCodeBadArgs       = 3
+	ExitCodeInternalError = 4
+	ExitCodeInterrupted   = 5 // SIGINT or SIGTERM before the outcome was known
+	ExitCodeAuthFailed    = 6 // The server rejected the credentials; not retried
+)
+
+// exitCodeInfo documents one exit code and the failure classes that end up with it.
+type exitCodeInfo struct {
+	Code    int      `json:"code"`
+	Name    string   `json:"name"`
+	Meaning string   `json:"meaning"`
+	Classes []string `json:"classes"`
+	Flag    string   `json:"-"` // Overrides the code as -exit-<Flag>; empty if it can't be overridden
+}
+
+// exitCodes is the exit code contract, used for -print-exit-codes and the usage message.
+// Add a row here whenever a new exit code or failure class is introduced.
+var exitCodes = []exitCodeInfo{
+	{ExitCodeOK, "ok", "Server is accepting connections and all checks passed.", []string{
+		"ready",
+		"with -invert: database not accepting connections",
+		"with -absent: the tables are gone (and the other checks passed)",
+	}, ""},
+	{ExitCodeConnFailed, "connection_failed", "Server connection failed (timeout, refused, etc.).", []string{
+		"connection refused, DNS or TLS failure until -timeout",
+		"with -retry-sqlstates: a connection error with an unlisted SQLSTATE, without waiting for -timeout",
+		"admin connection failure until -timeout",
+		"-timeout or -max-attempts exceeded while the last attempt couldn't connect",
+	}, "conn-failed"},
+	{ExitCodeCheckFailed, "check_failed", "Connection succeeded, but a check failed (tables missing, wrong settings).", []string{
+		"server misconfiguration that retrying won't fix (e.g. timezone, wal_level, settings, RLS disabled)",
+		"-timeout or -max-attempts exceeded while connected but a check was still failing (e.g. tables missing)",
+		"any failed check with -retry-on-checks=false",
+		"with -retry-sqlstates: a check query error with an unlisted SQLSTATE",
+		"with -invert: -timeout exceeded while the database was still accepting connections",
+		"with -absent: -timeout exceeded while some of the tables were still present",
+	}, "check-failed"},
+	{ExitCodeBadArgs, "bad_args", "Invalid command-line arguments.", []string{
+		"invalid flag value or combination",
+		"invalid connection parameters",
+	}, "bad-args"},
+	{ExitCodeInternalError, "internal_error", "Internal error.", []string{
+		"unexpected failure inside pg_ready_check itself",
+		"a check lacking the privileges to run its query (SQLSTATE 42501), e.g. -roles",
+	}, "internal-error"},
+	{ExitCodeInterrupted, "interrupted", "Interrupted by SIGINT or SIGTERM before the database was ready.", []string{
+		"signal received while connecting, checking or waiting to retry",
+	}, "interrupted"},
+	{ExitCodeAuthFailed, "auth_failed", "The server rejected the credentials (wrong password, no pg_hba.conf entry).", []string{
+		"SQLSTATE 28P01 or 28000 on the app or admin connection, without waiting for -timeout (unless listed in -retry-sqlstates)",
+	}, "auth-failed"},
+}
+
+// exitCodeOverrides holds the -exit-* flags, keyed by the default code they replace.
+var exitCodeOverrides = map[int]*int{}
+
+// registerExitCodeFlags adds an -exit-<name> flag to fs for each exit code that can be overridden,
+// defaulting to the code itself.
+func registerExitCodeFlags(fs *flag.FlagSet) {
+	for _, e := range exitCodes {
+		if e.Flag == "" {
+			continue
+		}
+		exitCodeOverrides[e.Code] = fs.Int("exit-"+e.Flag, e.Code, fmt.Sprintf("Exit code to use instead of %d for %s (0-255)", e.Code, e.Name))
+	}
+}
+
+// validateExitCodes checks the -exit-* flags are valid process exit codes.
+func validateExitCodes() error {
+	for _, e := range exitCodes {
+		if code, ok := exitCodeOverrides[e.Code]; ok && (*code < 0 || *code > 255) {
+			return fmt.Errorf("-exit-%s must be between 0 and 255, got %d", e.Flag, *code)
+		}
+	}
+	return nil
+}
+
+// resolveExitCode returns the code to exit with for one of the ExitCode constants, applying
+// any -exit-* override.
+func resolveExitCode(code int) int {
+	if override, ok := exitCodeOverrides[code]; ok {
+		return *override
+	}
+	return code
+}
+
+// printExitCodes writes the exit code contract to w as "text" or "json", with any -exit-*
+// overrides applied.
+func printExitCodes(w io.Writer, format string) error {
+	resolved := make([]exitCodeInfo, len(exitCodes))
+	for i, e := range exitCodes {
+		resolved[i] = e
+		resolved[i].Code = resolveExitCode(e.Code)
+	}
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resolved)
+	}
+	for _, e := range resolved {
+		if _, err := fmt.Fprintf(w, "%d %s: %s\n", e.Code, e.Name, e.Meaning); err != nil {
+			return err
+		}
+		for _, class := range e.Classes {
+			if _, err := fmt.Fprintf(w, "    - %s\n", class); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exitCodeFor maps why readycheck.Wait gave up to the exit code reporting it.
+func exitCodeFor(failure readycheck.Failure) int {
+	switch failure {
+	case "":
+		return ExitCodeOK
+	case readycheck.FailureConnection:
+		return ExitCodeConnFailed
+	case readycheck.FailureAuth:
+		return ExitCodeAuthFailed
+	case readycheck.FailureCheck:
+		return ExitCodeCheckFailed
+	case readycheck.FailureInterrupted:
+		return ExitCodeInterrupted
+	case readycheck.FailureInternal:
+		return ExitCodeInternalError
+	default:
+		return ExitCodeInternalError
+	}
+}
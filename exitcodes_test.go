@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alchen99/pg_ready_check/readycheck"
+)
+
+func TestResolveExitCode(t *testing.T) {
+	if _, err := parseTestOptions(t); err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	for _, e := range exitCodes {
+		if got := resolveExitCode(e.Code); got != e.Code {
+			t.Errorf("resolveExitCode(%d) without overrides = %d", e.Code, got)
+		}
+	}
+
+	if _, err := parseTestOptions(t, "-exit-check-failed", "75", "-exit-bad-args", "0", "-exit-auth-failed", "255"); err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	for code, want := range map[int]int{
+		ExitCodeOK:          ExitCodeOK,
+		ExitCodeConnFailed:  ExitCodeConnFailed,
+		ExitCodeCheckFailed: 75,
+		ExitCodeBadArgs:     0,
+		ExitCodeAuthFailed:  255,
+	} {
+		if got := resolveExitCode(code); got != want {
+			t.Errorf("resolveExitCode(%d) = %d, want %d", code, got, want)
+		}
+	}
+}
+
+func TestValidateExitCodes(t *testing.T) {
+	for _, tt := range []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"-exit-conn-failed", "-1"}, "-exit-conn-failed must be between 0 and 255, got -1"},
+		{[]string{"-exit-interrupted", "256"}, "-exit-interrupted must be between 0 and 255, got 256"},
+	} {
+		if _, err := parseTestOptions(t, tt.args...); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseOptions(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+		}
+		if err := validateExitCodes(); err == nil {
+			t.Errorf("validateExitCodes() after %q succeeded", tt.args)
+		}
+	}
+
+	// -exit-ok doesn't exist: a ready result always exits 0
+	if _, err := parseTestOptions(t, "-exit-ok", "1"); err == nil {
+		t.Error("parseOptions() accepted -exit-ok")
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	for failure, want := range map[readycheck.Failure]int{
+		"":                            ExitCodeOK,
+		readycheck.FailureConnection:  ExitCodeConnFailed,
+		readycheck.FailureAuth:        ExitCodeAuthFailed,
+		readycheck.FailureCheck:       ExitCodeCheckFailed,
+		readycheck.FailureInterrupted: ExitCodeInterrupted,
+		readycheck.FailureInternal:    ExitCodeInternalError,
+		"something new":               ExitCodeInternalError,
+	} {
+		if got := exitCodeFor(failure); got != want {
+			t.Errorf("exitCodeFor(%q) = %d, want %d", failure, got, want)
+		}
+	}
+}
+
+func TestPrintExitCodes(t *testing.T) {
+	if _, err := parseTestOptions(t, "-exit-check-failed", "75"); err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	var sb strings.Builder
+	if err := printExitCodes(&sb, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var got []exitCodeInfo
+	if err := json.Unmarshal([]byte(sb.String()), &got); err != nil {
+		t.Fatalf("-print-exit-codes json: %v\n%s", err, sb.String())
+	}
+	if len(got) != len(exitCodes) {
+		t.Fatalf("%d exit codes, want %d", len(got), len(exitCodes))
+	}
+	for i, e := range got {
+		want := resolveExitCode(exitCodes[i].Code)
+		if e.Code != want || e.Name != exitCodes[i].Name || len(e.Classes) == 0 {
+			t.Errorf("exit code %+v, want code %d for %s with its classes", e, want, exitCodes[i].Name)
+		}
+	}
+
+	sb.Reset()
+	if err := printExitCodes(&sb, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "\n75 check_failed: ") || strings.Contains(sb.String(), "\n2 check_failed") {
+		t.Errorf("-print-exit-codes text doesn't show the override:\n%s", sb.String())
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// options are the settings from the command line flags, the -config file and the environment
+// variables the flags default to, followed by what validate derives from them.
+type options struct {
+	dbHost           string
+	dbPort           int
+	dbUser           string
+	dbName           string
+	dsn              string
+	appName          string
+	tablesToCheck    string
+	columnsToCheck   string
+	viewsToCheck     string
+	indexesToCheck   string
+	rolesToCheck     string
+	listenChannel    string
+	viewsPopulated   bool
+	defaultSchema    string
+	exactCase        bool
+	requireRows      bool
+	timeout          time.Duration
+	connTimeout      time.Duration
+	dialTimeout      time.Duration
+	socksProxy       string
+	tcpPrecheck      time.Duration
+	queryTimeout     time.Duration
+	stmtTimeout      time.Duration
+	retryInterval    time.Duration
+	warnAfter        time.Duration
+	sslMode          string
+	rootCertInline   string
+	rootCertFile     string
+	clientCertFile   string
+	clientKeyFile    string
+	quiet            bool
+	verbose          bool
+	logLevelName     string
+	logFormat        string
+	logFile          string
+	outputFormat     string
+	resultTemplate   string
+	failureOnly      bool
+	retryOnChecks    bool
+	noWait           bool
+	keepalive        bool
+	keepaliveEvery   time.Duration
+	successCount     int
+	skipPing         bool
+	maxAttempts      int
+	checkConcurrency int
+	retrySQLStates   string
+	reuseConn        bool
+	skipChecks       string
+	invert           bool
+	tablesAbsent     bool
+	requireTimezone  string
+	minFreeConns     int
+	probeWrite       bool
+	drainApp         string
+	requireWalLevel  string
+	requireSync      bool
+	requirePrimary   bool
+	requireReplica   bool
+	maxReplicaLag    time.Duration
+	maxLockAge       time.Duration
+	settingsMin      string
+	collVersions     bool
+	junitOutput      string
+	metricsFile      string
+	webhookURL       string
+	webhookTimeout   time.Duration
+	tableCheckTmpl   string
+	checkQuery       string
+	checkExpect      string
+	migrationTable   string
+	migrationColumn  string
+	minMigration     string
+	migrationOrder   string
+	typesToCheck     string
+	domainsToCheck   string
+	requireRLS       string
+	forceRLS         bool
+	accessMethods    string
+	partitionCounts  string
+	sequenceFloors   string
+	maxBloat         string
+	bloatFatal       bool
+	extensions       string
+	availableExts    string
+	rowsAbsent       string
+	eventTriggers    string
+	evtEnabled       bool
+	fdwServers       string
+	foreignTables    string
+	requireMapping   bool
+	defaultPrivs     string
+	adminUser        string
+	adminPassword    string
+	passwordFile     string
+	dumpConfig       bool
+	showExitCodes    bool
+	printVersion     bool
+	versionJSON      bool
+	configFile       string
+	validateOnly     bool
+
+	given             map[string]bool // Flags given on the command line or in the -config file
+	tableCheckQuery   string          // From -table-check-query; empty means the built-in query
+	mainTables        []string        // The -tables in the database connected to
+	otherDBTables     []databaseTables
+	requiredColumns   map[string][]string
+	defaultPrivSpecs  []defaultPrivilegeSpec
+	accessMethodSpecs []namedValue
+	partitionMins     []partitionMinimum
+	sequenceMins      []sequenceMinimum
+	eventTriggerSpecs []eventTriggerSpec
+	absentRowFilters  []rowFilter
+	bloatLimits       []bloatLimit
+	settingReqs       []settingRequirement
+	tlsOpts           tlsOptions
+	proxyURL          *url.URL // From -socks5; nil connects directly
+	logLevel          slog.Level
+	resultTmpl        *template.Template // From -template
+	retrySQLStateList []string           // Nil keeps the default policy
+}
+
+// registerFlags defines the flags in fs, to be parsed into o. Their defaults come from the
+// environment.
+func registerFlags(fs *flag.FlagSet, o *options) {
+	// Get OS user for default username if PGDATABASE is not set
+	osUser, err := os.UserHomeDir() // Using home dir as a proxy for username often works, but might not be perfect
+	if err == nil {
+		parts := strings.Split(osUser, string(os.PathSeparator))
+		osUser = parts[len(parts)-1]
+	} else {
+		osUser = "user" // Fallback
+	}
+	defaultUser := getEnvOrDefault("PGUSER", osUser)
+	defaultDbName := getEnvOrDefault("PGDATABASE", defaultUser) // Often defaults to username
+	// Bad values are reported by validate, unless the flags override them
+	defaultPort, _ := getEnvOrDefaultInt("PGPORT", DefaultPort)
+	defaultConnSecs, _ := getEnvOrDefaultInt("PGCONNECT_TIMEOUT", int(DefaultConnTimeout/time.Second))
+
+	fs.StringVar(&o.dbHost, "host", getEnvOrDefault("PGHOST", DefaultHost), "Database server host or socket directory; several as 'h1,h2:5433' are tried in order (env: PGHOST)")
+	fs.IntVar(&o.dbPort, "port", defaultPort, "Database server port (env: PGPORT)")
+	fs.StringVar(&o.dbUser, "username", defaultUser, "Database user name (env: PGUSER)")
+	fs.StringVar(&o.dbName, "dbname", defaultDbName, "Database name to connect to (env: PGDATABASE)")
+	fs.StringVar(&o.dsn, "dsn", os.Getenv("DATABASE_URL"), "Connection string (postgres://... URL or key=value pairs); overrides -host, -port, -username, -dbname and -sslmode (env: DATABASE_URL)")
+	fs.StringVar(&o.appName, "application-name", getEnvOrDefault("PGAPPNAME", "pg_ready_check"), "application_name to connect with, so the probe is recognizable in pg_stat_activity; a -dsn's own setting wins (env: PGAPPNAME)")
+	fs.StringVar(&o.tablesToCheck, "tables", "", "Comma-separated list of tables to check for existence (e.g., 'users,products'); tables in other databases of the server as 'db1:users,orders;db2:events'")
+	fs.StringVar(&o.viewsToCheck, "views", "", "Comma-separated list of views or materialized views that must exist (e.g. 'active_users,reports.daily_totals')")
+	fs.BoolVar(&o.viewsPopulated, "require-populated", false, "With -views, also require materialized views to be populated (not created WITH NO DATA)")
+	fs.StringVar(&o.indexesToCheck, "indexes", "", "Comma-separated list of indexes that must exist (e.g. 'users_email_idx,billing.invoices_due_idx')")
+	fs.StringVar(&o.listenChannel, "listen-channel", "", "LISTEN on this channel and wait for a NOTIFY on it (e.g. from a migration runner) before running the other checks")
+	fs.StringVar(&o.rolesToCheck, "roles", "", "Comma-separated list of roles (users or groups) that must exist (e.g. 'app,app_readonly')")
+	fs.StringVar(&o.columnsToCheck, "columns", "", "Semicolon-separated columns that must exist, as table:column[,column...] (e.g. 'users:id,email;orders:total')")
+	fs.DurationVar(&o.maxLockAge, "max-lock-age", 0, "With -tables, wait until no other session has held a lock on them for longer than this, e.g. before an ALTER TABLE (0 disables)")
+	fs.BoolVar(&o.requireRows, "require-rows", false, "With -tables, also wait until each table has at least one row (an empty table counts as not ready)")
+	fs.StringVar(&o.defaultSchema, "schema", cmp.Or(searchPathSchema(os.Getenv("PGOPTIONS")), "public"), "Schema for table, type and other object names given without one, taken from a search_path in PGOPTIONS if there is one")
+	fs.BoolVar(&o.exactCase, "exact-case", false, "Match object names exactly as given instead of folding unquoted ones to lower case like PostgreSQL (quote them, e.g. '\"Users\"', to keep case without this)")
+	fs.StringVar(&o.tableCheckTmpl, "table-check-query", "", "Advanced: custom SQL template for the table existence check, using {{.Schema}} and {{.Table}}; any returned row means the table exists")
+	fs.StringVar(&o.checkQuery, "check-query", "", "Custom SQL query; ready only once its first column is true (or equals -check-query-expect), e.g. for a migration version")
+	fs.StringVar(&o.checkExpect, "check-query-expect", "", "With -check-query, the value (as text) the query must return instead of true")
+	fs.StringVar(&o.minMigration, "min-migration-version", "", "Wait until the latest applied migration in -migration-table is at least this version (e.g. 20241015120000)")
+	fs.StringVar(&o.migrationTable, "migration-table", "schema_migrations", "With -min-migration-version, the table the migration tool records applied versions in")
+	fs.StringVar(&o.migrationColumn, "migration-version-column", "version", "With -min-migration-version, the column of -migration-table holding the version")
+	fs.StringVar(&o.migrationOrder, "migration-version-order", "numeric", "With -min-migration-version, compare versions as 'numeric' (integers or timestamps like 20241015120000) or 'lexical' (as text)")
+	fs.DurationVar(&o.timeout, "timeout", DefaultTimeout, "Maximum time to wait for connection and checks")
+	fs.DurationVar(&o.connTimeout, "conn-timeout", time.Duration(defaultConnSecs)*time.Second, "Timeout for each connection attempt (env: PGCONNECT_TIMEOUT, in seconds)")
+	fs.DurationVar(&o.dialTimeout, "dial-timeout", 0, "Timeout for the network connect (TCP/socket dial) of each attempt, independent of -conn-timeout (default: same as -conn-timeout)")
+	fs.StringVar(&o.socksProxy, "socks5", socksProxyFromEnv(), "Connect through this SOCKS5 proxy, e.g. a bastion: socks5://[user:password@]host[:port], or socks5h:// to have it resolve the database host (env: ALL_PROXY, if socks5)")
+	fs.DurationVar(&o.tcpPrecheck, "tcp-precheck", 0, "Before each connection attempt, dial the server's TCP port with this timeout, and retry without the full connect while it isn't open (e.g. 500ms; 0 disables)")
+	fs.DurationVar(&o.queryTimeout, "query-timeout", 0, "Timeout for each check's queries; a check that times out is retried (default: same as -conn-timeout)")
+	fs.DurationVar(&o.stmtTimeout, "statement-timeout", 0, "Set the server's statement_timeout on our connections, so it cancels check queries that run longer, which -query-timeout alone doesn't (e.g. 30s; 0 leaves it alone)")
+	fs.DurationVar(&o.retryInterval, "retry-interval", DefaultRetryInterval, "Wait time between attempts")
+	fs.DurationVar(&o.warnAfter, "warn-after", 0, "Log a warning and mark the result as slow if readiness succeeds but takes longer than this (0 disables)")
+	fs.StringVar(&o.sslMode, "sslmode", getEnvOrDefault("PGSSLMODE", "prefer"), "SSL mode: disable, allow, prefer, require, verify-ca or verify-full (env: PGSSLMODE)")
+	fs.StringVar(&o.rootCertFile, "sslrootcert", os.Getenv("PGSSLROOTCERT"), "File with the CA certificate(s) to verify the server with (env: PGSSLROOTCERT)")
+	fs.StringVar(&o.clientCertFile, "sslcert", os.Getenv("PGSSLCERT"), "Client certificate file for mutual TLS, needs -sslkey (env: PGSSLCERT)")
+	fs.StringVar(&o.clientKeyFile, "sslkey", os.Getenv("PGSSLKEY"), "Client private key file for mutual TLS, needs -sslcert (env: PGSSLKEY)")
+	fs.StringVar(&o.rootCertInline, "sslrootcert-inline", os.Getenv("PGSSLROOTCERT_INLINE"), "PEM content of the CA certificate(s) to verify the server with; implies -sslmode verify-full unless verify-ca (env: PGSSLROOTCERT_INLINE)")
+	fs.StringVar(&o.adminUser, "admin-user", "", "Privileged user for checks that need elevated access (see README); app credentials are used otherwise")
+	fs.StringVar(&o.passwordFile, "password-file", os.Getenv("PGPASSWORD_FILE"), "File holding the password (e.g. a mounted Docker or Kubernetes secret); wins over PGPASSWORD and a -dsn password (env: PGPASSWORD_FILE)")
+	fs.StringVar(&o.adminPassword, "admin-password", os.Getenv("PG_READY_ADMIN_PASSWORD"), "Password for -admin-user (env: PG_READY_ADMIN_PASSWORD)")
+	fs.StringVar(&o.typesToCheck, "types", "", "Comma-separated list of composite types that must exist (e.g. 'address,billing.money_range')")
+	fs.StringVar(&o.domainsToCheck, "domains", "", "Comma-separated list of domains that must exist (e.g. 'email,billing.positive_amount')")
+	fs.StringVar(&o.requireRLS, "require-rls", "", "Comma-separated list of tables that must have row-level security enabled")
+	fs.BoolVar(&o.forceRLS, "require-forced-rls", false, "With -require-rls, also require FORCE ROW LEVEL SECURITY (applies to table owners)")
+	fs.StringVar(&o.accessMethods, "table-access-method", "", "Comma-separated table=access_method pairs the tables must use (e.g. 'events=columnar,users=heap')")
+	fs.StringVar(&o.partitionCounts, "partition-counts", "", "Comma-separated table:N pairs; wait until each partitioned table has at least N partitions (e.g. 'events:12')")
+	fs.StringVar(&o.sequenceFloors, "sequences", "", "Comma-separated sequence:N pairs; wait until each sequence's last value is at least N, e.g. a shard's provisioned ID range (e.g. 'orders_id_seq:1000000')")
+	fs.StringVar(&o.maxBloat, "max-bloat", "", "Comma-separated table:N% pairs; wait until each table's dead tuples are at most N% (needs the pgstattuple extension, e.g. 'big_table:20%')")
+	fs.BoolVar(&o.bloatFatal, "max-bloat-fatal", false, "With -max-bloat, fail immediately when a table is over its limit instead of waiting for vacuum")
+	fs.StringVar(&o.eventTriggers, "event-triggers", "", "Comma-separated event triggers that must exist, as name[:event[:function]] (e.g. 'audit_ddl:ddl_command_end:audit.log_ddl')")
+	fs.BoolVar(&o.evtEnabled, "require-enabled-event-triggers", false, "With -event-triggers, also require the triggers to be enabled")
+	fs.StringVar(&o.rowsAbsent, "rows-absent", "", "Semicolon-separated rows that must not exist, as table:column=value[,column=value...] (e.g. 'feature_flags:name=legacy_feature')")
+	fs.StringVar(&o.extensions, "extensions", "", "Comma-separated list of extensions that must be installed in the database (e.g. 'uuid-ossp,postgis')")
+	fs.StringVar(&o.availableExts, "available-extensions", "", "Comma-separated list of extensions that must be installable on the server (present in pg_available_extensions)")
+	fs.StringVar(&o.fdwServers, "fdw-servers", "", "Comma-separated list of foreign servers (pg_foreign_server) that must exist")
+	fs.BoolVar(&o.requireMapping, "require-user-mapping", false, "With -fdw-servers, also require a user mapping for the connecting user (or PUBLIC) on each server")
+	fs.StringVar(&o.foreignTables, "foreign-tables", "", "Comma-separated list of foreign tables that must exist (e.g. 'remote.orders')")
+	fs.StringVar(&o.defaultPrivs, "default-privileges", "", "Semicolon-separated default privileges that must be set (e.g. 'app.tables:reader=SELECT;app.sequences:reader=USAGE')")
+	fs.StringVar(&o.requireTimezone, "require-timezone", "", "Fail unless the server's TimeZone setting matches this value (e.g. 'UTC')")
+	fs.StringVar(&o.requireWalLevel, "require-wal-level", "", "Fail unless the server's wal_level is at least this level (minimal, replica, logical)")
+	fs.BoolVar(&o.requirePrimary, "require-primary", false, "Wait until the server is out of recovery and accepting writes (pg_is_in_recovery() is false)")
+	fs.BoolVar(&o.requireReplica, "require-replica", false, "Wait until the server is in recovery, i.e. a replica (pg_is_in_recovery() is true)")
+	fs.DurationVar(&o.maxReplicaLag, "max-replica-lag", 0, "Wait until the replica's replay is at most this far behind the primary (0 disables); fails on a primary")
+	fs.BoolVar(&o.requireSync, "require-sync-standbys", false, "Wait until the standbys required by synchronous_standby_names are streaming in sync (on a primary)")
+	fs.StringVar(&o.settingsMin, "settings-min", "", "Comma-separated numeric setting requirements, unit-aware (e.g. 'max_prepared_transactions>=10,work_mem>=4MB')")
+	fs.BoolVar(&o.probeWrite, "probe-temp-write", false, "Create a temp table and insert a row (rolled back) to verify the server can write, e.g. isn't out of disk")
+	fs.BoolVar(&o.collVersions, "check-collation-versions", false, "Warn about collations whose version changed since they were recorded (e.g. after a glibc upgrade)")
+	fs.IntVar(&o.minFreeConns, "min-free-connections", 0, "Wait until at least this many connection slots are free below max_connections (0 disables)")
+	fs.StringVar(&o.drainApp, "drain", "", "Wait until no other connections with this application_name remain (for shutdown coordination)")
+	fs.StringVar(&o.junitOutput, "junit-output", "", "Write a JUnit XML report of the checks to this file on exit")
+	fs.StringVar(&o.webhookURL, "webhook-url", "", "POST the result as JSON (like -output=json) to this URL on exit, e.g. for a deploy dashboard")
+	fs.DurationVar(&o.webhookTimeout, "webhook-timeout", 5*time.Second, "Time limit for delivering the -webhook-url request")
+	fs.StringVar(&o.metricsFile, "metrics-file", "", "Write Prometheus metrics of the run to this file on exit, e.g. for node_exporter's textfile collector")
+	fs.BoolVar(&o.quiet, "quiet", false, "Run quietly, only exit code matters")
+	fs.BoolVar(&o.verbose, "verbose", false, "Log diagnostics: every attempt and its error, the effective connection config (password masked), the server version and per-phase timings; same as -log-level=debug plus the config")
+	fs.StringVar(&o.logLevelName, "log-level", "info", "Minimum level of log messages: debug (every attempt), info, warn or error")
+	fs.StringVar(&o.logFormat, "log-format", "text", "Log message format: 'text' (key=value) or 'json'")
+	fs.StringVar(&o.logFile, "log-file", "", "Append log messages to this file instead of writing them to stderr; '-' writes them to stdout")
+	fs.StringVar(&o.outputFormat, "output", "", "Print a result summary to stdout on exit: 'text', 'json' or 'csv' (one row per checked object)")
+	fs.StringVar(&o.resultTemplate, "template", "", "Print the result to stdout on exit through this Go text/template instead of -output (e.g. 'ready={{.Ready}} ms={{.DurationMs}} missing={{len .MissingTables}}')")
+	fs.BoolVar(&o.failureOnly, "output-on-failure-only", false, "Print nothing at all on success; on failure print the logs and the full result (text unless -output says otherwise)")
+	fs.BoolVar(&o.retryOnChecks, "retry-on-checks", true, "Retry failed object checks (e.g. missing tables) until timeout; if false, fail immediately once connected")
+	fs.BoolVar(&o.noWait, "no-wait", false, "Make a single attempt and exit with its outcome instead of retrying until -timeout, like pg_isready")
+	fs.BoolVar(&o.keepalive, "keepalive", false, "Once ready, keep running with a connection open, pinging it every -keepalive-interval, until stopped by a signal (exit 0) or the database goes away (exit 1)")
+	fs.DurationVar(&o.keepaliveEvery, "keepalive-interval", 10*time.Second, "With -keepalive, the time between pings")
+	fs.IntVar(&o.successCount, "count", 1, "Require this many consecutive successful attempts, separated by the retry interval, before reporting ready; any failure starts over")
+	fs.IntVar(&o.maxAttempts, "max-attempts", 0, "Give up after this many failed attempts, even if -timeout hasn't run out (0: unlimited)")
+	fs.StringVar(&o.retrySQLStates, "retry-sqlstates", "", "Comma-separated SQLSTATEs to retry when the connection or a check query fails (e.g. '57P03,53300'); any other server error gives up at once. Default: all but rejected credentials")
+	fs.IntVar(&o.checkConcurrency, "check-concurrency", 1, "Run up to this many checks at once, each on a connection of its own, to save round trips on a slow link (1: one after the other)")
+	fs.BoolVar(&o.skipPing, "skip-ping", false, "Count a completed connection as ready without pinging the server (e.g. behind pgbouncer in transaction pooling mode)")
+	fs.BoolVar(&o.reuseConn, "reuse-connection", false, "Keep the connection open between retries and only re-run the checks that haven't passed yet; reconnects if it drops")
+	fs.StringVar(&o.skipChecks, "skip-checks", "", "Comma-separated names of configured checks to disable, as shown in -output (e.g. 'table bloat,default privileges'); they're reported as skipped")
+	fs.BoolVar(&o.invert, "invert", false, "Wait for the opposite: the database not accepting connections (see README); use -absent for tables to be gone")
+	fs.BoolVar(&o.tablesAbsent, "absent", false, "Wait for the -tables and -foreign-tables to be gone instead of present, while the connection and any other checks must still pass")
+	fs.BoolVar(&o.dumpConfig, "dump-config", false, "Log the fully resolved connection config (password masked) before connecting")
+	fs.BoolVar(&o.showExitCodes, "print-exit-codes", false, "Print every exit code with the failure classes that map to it and exit (as JSON with -output json)")
+	fs.BoolVar(&o.validateOnly, "validate", false, "Resolve flags and environment into the connection settings, print them with where each came from, and exit without connecting")
+	fs.BoolVar(&o.printVersion, "version", false, "Print version information and exit")
+	fs.BoolVar(&o.versionJSON, "version-json", false, "Print version information as JSON and exit")
+	fs.StringVar(&o.configFile, "config", "", "YAML file setting any of these flags by name (e.g. 'tables: [users, orders]'); the environment and the command line override it")
+	registerExitCodeFlags(fs)
+}
+
+// parseOptions parses args with the flags registerFlags defines in fs, then applies the -config
+// file and validates the result. The error names the setting that's invalid.
+func parseOptions(fs *flag.FlagSet, args []string) (*options, error) {
+	o := &options{}
+	registerFlags(fs, o)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if o.configFile != "" {
+		cfg, err := loadConfigFile(o.configFile)
+		if err == nil {
+			err = cfg.apply(fs)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("-config: %w", err)
+		}
+	}
+	if err := validateExitCodes(); err != nil {
+		return nil, fmt.Errorf("exit code: %w", err)
+	}
+	o.given = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { o.given[f.Name] = true })
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// validate checks the flag values and their combinations, and parses the ones holding lists and
+// specs into the fields after them.
+func (o *options) validate() error {
+	// A bad PGPORT only matters if -port doesn't override it
+	if _, err := getEnvOrDefaultInt("PGPORT", DefaultPort); err != nil && !o.given["port"] {
+		return fmt.Errorf("PGPORT: %w", err)
+	}
+	if !o.given["conn-timeout"] {
+		secs, err := getEnvOrDefaultInt("PGCONNECT_TIMEOUT", int(DefaultConnTimeout/time.Second))
+		if err == nil && secs < 1 {
+			err = fmt.Errorf("must be at least 1 second, got %d", secs)
+		}
+		if err != nil {
+			return fmt.Errorf("PGCONNECT_TIMEOUT: %w", err)
+		}
+	}
+	var err error
+	if o.tableCheckTmpl != "" {
+		o.tableCheckQuery, err = renderTableCheckQuery(o.tableCheckTmpl)
+		if err != nil {
+			return fmt.Errorf("-table-check-query: %w", err)
+		}
+	}
+	o.defaultPrivSpecs, err = parseDefaultPrivileges(o.defaultPrivs)
+	if err != nil {
+		return fmt.Errorf("-default-privileges: %w", err)
+	}
+	o.accessMethodSpecs, err = parseNamedValues(o.accessMethods, "=")
+	if err != nil {
+		return fmt.Errorf("-table-access-method: %w", err)
+	}
+	o.mainTables, o.otherDBTables, err = parseTablesByDatabase(o.tablesToCheck)
+	if err != nil {
+		return fmt.Errorf("-tables: %w", err)
+	}
+	o.partitionMins, err = parsePartitionCounts(o.partitionCounts)
+	if err != nil {
+		return fmt.Errorf("-partition-counts: %w", err)
+	}
+	o.sequenceMins, err = parseSequenceMinimums(o.sequenceFloors)
+	if err != nil {
+		return fmt.Errorf("-sequences: %w", err)
+	}
+	for i := range o.sequenceMins {
+		o.sequenceMins[i].sequence = foldIdentifiers([]string{o.sequenceMins[i].sequence}, o.exactCase)[0]
+	}
+	o.eventTriggerSpecs, err = parseEventTriggers(o.eventTriggers)
+	if err != nil {
+		return fmt.Errorf("-event-triggers: %w", err)
+	}
+	parsedColumns, err := parseColumnLists(o.columnsToCheck)
+	if err != nil {
+		return fmt.Errorf("-columns: %w", err)
+	}
+	o.requiredColumns = map[string][]string{}
+	for table, columns := range parsedColumns {
+		folded := foldIdentifiers([]string{table}, o.exactCase)[0]
+		o.requiredColumns[folded] = appendNew(o.requiredColumns[folded], unquoteIdentifiers(foldIdentifiers(columns, o.exactCase))...)
+	}
+	o.absentRowFilters, err = parseRowFilters(o.rowsAbsent)
+	if err != nil {
+		return fmt.Errorf("-rows-absent: %w", err)
+	}
+	o.bloatLimits, err = parseBloatLimits(o.maxBloat)
+	if err != nil {
+		return fmt.Errorf("-max-bloat: %w", err)
+	}
+	o.settingReqs, err = parseSettingRequirements(o.settingsMin)
+	if err != nil {
+		return fmt.Errorf("-settings-min: %w", err)
+	}
+	if !slices.Contains(sslModes, o.sslMode) {
+		return fmt.Errorf("-sslmode '%s': must be one of %s", o.sslMode, strings.Join(sslModes, ", "))
+	}
+	o.tlsOpts = tlsOptions{sslMode: o.sslMode, rootCertFile: o.rootCertFile, certFile: o.clientCertFile, keyFile: o.clientKeyFile}
+	if (o.clientCertFile == "") != (o.clientKeyFile == "") {
+		return errors.New("-sslcert/-sslkey: both are needed for a client certificate")
+	}
+	if o.rootCertInline != "" {
+		if o.rootCertFile != "" {
+			return errors.New("-sslrootcert-inline: can't be combined with -sslrootcert")
+		}
+		if o.sslMode == "disable" {
+			return errors.New("-sslrootcert-inline: can't verify the server with -sslmode disable")
+		}
+		o.tlsOpts.rootCAs, err = parseInlineRootCert(o.rootCertInline)
+		if err != nil {
+			return fmt.Errorf("-sslrootcert-inline: %w", err)
+		}
+	}
+	if o.warnAfter < 0 {
+		return fmt.Errorf("-warn-after '%s': must not be negative", o.warnAfter)
+	}
+	if o.checkExpect != "" && o.checkQuery == "" {
+		return errors.New("-check-query-expect: needs -check-query")
+	}
+	if o.migrationOrder != "numeric" && o.migrationOrder != "lexical" {
+		return fmt.Errorf("-migration-version-order '%s': must be numeric or lexical", o.migrationOrder)
+	}
+	if o.minMigration != "" && o.migrationOrder == "numeric" {
+		if _, err := strconv.ParseFloat(o.minMigration, 64); err != nil {
+			return fmt.Errorf("-min-migration-version '%s': not a number, use -migration-version-order=lexical for other versions", o.minMigration)
+		}
+	}
+	if o.minMigration == "" && (o.given["migration-table"] || o.given["migration-version-column"] || o.given["migration-version-order"]) {
+		return errors.New("-migration-table, -migration-version-column or -migration-version-order: needs -min-migration-version")
+	}
+	if o.dialTimeout < 0 {
+		return fmt.Errorf("-dial-timeout '%s': must not be negative", o.dialTimeout)
+	}
+	if o.dialTimeout == 0 {
+		o.dialTimeout = o.connTimeout
+	}
+	if o.socksProxy != "" {
+		if o.proxyURL, err = parseSocksProxy(o.socksProxy); err != nil {
+			return fmt.Errorf("-socks5: %w", err)
+		}
+	}
+	if o.tcpPrecheck < 0 {
+		return fmt.Errorf("-tcp-precheck '%s': must not be negative", o.tcpPrecheck)
+	}
+	if o.tcpPrecheck > 0 && o.proxyURL != nil {
+		return errors.New("-tcp-precheck: can't be combined with -socks5")
+	}
+	if o.queryTimeout < 0 {
+		return fmt.Errorf("-query-timeout '%s': must not be negative", o.queryTimeout)
+	}
+	if o.queryTimeout == 0 {
+		o.queryTimeout = o.connTimeout
+	}
+	if o.stmtTimeout < 0 || (o.stmtTimeout > 0 && o.stmtTimeout < time.Millisecond) {
+		return fmt.Errorf("-statement-timeout '%s': must be 0 or at least 1ms", o.stmtTimeout)
+	}
+	if o.webhookURL != "" {
+		if u, err := url.Parse(o.webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("-webhook-url: must be an http or https URL")
+		}
+	}
+	if o.webhookTimeout <= 0 {
+		return fmt.Errorf("-webhook-timeout '%s': must be positive", o.webhookTimeout)
+	}
+	if o.keepaliveEvery <= 0 {
+		return fmt.Errorf("-keepalive-interval '%s': must be positive", o.keepaliveEvery)
+	}
+	if o.keepalive && o.invert {
+		return errors.New("-keepalive: can't be combined with -invert")
+	}
+	if o.tablesAbsent && o.invert {
+		return errors.New("-absent: can't be combined with -invert, which waits for the database itself to be gone")
+	}
+	if o.invert && (o.tablesToCheck != "" || o.foreignTables != "") {
+		// Gone tables or a gone database? -absent says the former without a doubt.
+		return errors.New("-invert: only waits for the database to stop accepting connections; use -absent to wait for tables to be gone")
+	}
+	if o.tablesAbsent && o.tablesToCheck == "" && o.foreignTables == "" {
+		return errors.New("-absent: needs -tables or -foreign-tables")
+	}
+	if o.tablesAbsent && o.requireRows {
+		return errors.New("-absent: can't be combined with -require-rows")
+	}
+	if o.tablesAbsent && o.maxLockAge > 0 {
+		return errors.New("-absent: can't be combined with -max-lock-age, which looks at the tables")
+	}
+	if o.retryInterval <= 0 {
+		return fmt.Errorf("-retry-interval '%s': must be positive", o.retryInterval)
+	}
+	if o.outputFormat != "" && o.outputFormat != "text" && o.outputFormat != "json" && o.outputFormat != "csv" {
+		return fmt.Errorf("-output '%s': must be text, json or csv", o.outputFormat)
+	}
+	level, ok := logLevels[strings.ToLower(o.logLevelName)]
+	if !ok {
+		return fmt.Errorf("-log-level '%s': must be debug, info, warn or error", o.logLevelName)
+	}
+	o.logLevel = level
+	if o.verbose {
+		if o.quiet {
+			return errors.New("-verbose: can't be combined with -quiet")
+		}
+		if o.given["log-level"] && o.logLevel != slog.LevelDebug {
+			return fmt.Errorf("-verbose: can't be combined with -log-level %s", o.logLevelName)
+		}
+		o.logLevel = slog.LevelDebug
+	}
+	if o.logFormat != "text" && o.logFormat != "json" {
+		return fmt.Errorf("-log-format '%s': must be text or json", o.logFormat)
+	}
+	if o.resultTemplate != "" {
+		if o.outputFormat != "" {
+			return errors.New("-template: can't be combined with -output")
+		}
+		if o.resultTmpl, err = parseResultTemplate(o.resultTemplate); err != nil {
+			return fmt.Errorf("-template: %w", err)
+		}
+	}
+	if o.failureOnly && o.outputFormat == "" && o.resultTmpl == nil {
+		o.outputFormat = "text"
+	}
+	if o.maxAttempts < 0 {
+		return fmt.Errorf("-max-attempts %d: must not be negative", o.maxAttempts)
+	}
+	if o.given["retry-sqlstates"] {
+		o.retrySQLStateList = parseTableList(strings.ToUpper(o.retrySQLStates))
+		for _, code := range o.retrySQLStateList {
+			if !isSQLState(code) {
+				return fmt.Errorf("-retry-sqlstates '%s': must be five digits or letters, like 57P03", code)
+			}
+		}
+		if o.retrySQLStateList == nil {
+			o.retrySQLStateList = []string{} // Given but empty: retry no server errors at all
+		}
+	}
+	if o.checkConcurrency < 1 {
+		return fmt.Errorf("-check-concurrency %d: must be at least 1", o.checkConcurrency)
+	}
+	if o.successCount < 1 {
+		return fmt.Errorf("-count %d: must be at least 1", o.successCount)
+	}
+	if o.noWait && o.successCount > 1 {
+		return fmt.Errorf("-count: can't require %d successes with -no-wait", o.successCount)
+	}
+	if o.listenChannel != "" && o.successCount > 1 {
+		return fmt.Errorf("-count: can't require %d successes with -listen-channel, which is a one-off event", o.successCount)
+	}
+	if o.requirePrimary && o.requireReplica {
+		return errors.New("-require-primary: can't be combined with -require-replica")
+	}
+	if o.maxReplicaLag < 0 {
+		return fmt.Errorf("-max-replica-lag '%s': must not be negative", o.maxReplicaLag)
+	}
+	if o.maxLockAge < 0 {
+		return fmt.Errorf("-max-lock-age '%s': must not be negative", o.maxLockAge)
+	}
+	if o.maxLockAge > 0 && len(o.mainTables) == 0 {
+		return errors.New("-max-lock-age: needs -tables in the database connected to")
+	}
+	if o.requirePrimary && o.maxReplicaLag > 0 {
+		return errors.New("-require-primary: can't be combined with -max-replica-lag")
+	}
+	if o.requireWalLevel != "" {
+		// Not the pre-9.6 names walLevelRank also has, which are only for what old servers report
+		if !slices.Contains([]string{"minimal", "replica", "logical"}, strings.ToLower(o.requireWalLevel)) {
+			return fmt.Errorf("-require-wal-level '%s': must be minimal, replica or logical", o.requireWalLevel)
+		}
+	}
+	return nil
+}
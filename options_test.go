@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseTestOptions parses args like main does, but on a flag set of its own. The -exit-* overrides
+// it registers are dropped when the test ends.
+func parseTestOptions(t *testing.T, args ...string) (*options, error) {
+	t.Helper()
+	t.Cleanup(func() { clear(exitCodeOverrides) })
+	fs := flag.NewFlagSet("pg_ready_check", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return parseOptions(fs, args)
+}
+
+func TestParseOptionsDefaults(t *testing.T) {
+	t.Setenv("PGCONNECT_TIMEOUT", "7")
+	o, err := parseTestOptions(t)
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	if o.connTimeout != 7*time.Second || o.dialTimeout != o.connTimeout || o.queryTimeout != o.connTimeout {
+		t.Errorf("conn, dial, query timeouts = %s, %s, %s; want all 7s", o.connTimeout, o.dialTimeout, o.queryTimeout)
+	}
+	if o.logLevel != slog.LevelInfo || o.outputFormat != "" || o.retrySQLStateList != nil || o.proxyURL != nil {
+		t.Errorf("options = %+v, want the defaults", o)
+	}
+	if len(o.given) != 0 {
+		t.Errorf("given = %v, want none", o.given)
+	}
+}
+
+func TestParseOptionsDerived(t *testing.T) {
+	o, err := parseTestOptions(t, "-verbose", "-output-on-failure-only", "-retry-sqlstates", "57p03, 53300",
+		"-tables", "Users;db2:events", "-columns", "Users:ID", "-exact-case", "-dial-timeout", "1s", "-sslmode", "disable")
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	if o.logLevel != slog.LevelDebug {
+		t.Errorf("-verbose: log level = %s, want debug", o.logLevel)
+	}
+	if o.outputFormat != "text" {
+		t.Errorf("-output-on-failure-only: output = %q, want text", o.outputFormat)
+	}
+	if !slices.Equal(o.retrySQLStateList, []string{"57P03", "53300"}) {
+		t.Errorf("retry SQLSTATEs = %v", o.retrySQLStateList)
+	}
+	if !slices.Equal(o.mainTables, []string{"Users"}) || len(o.otherDBTables) != 1 || o.otherDBTables[0].database != "db2" {
+		t.Errorf("tables = %v, %+v", o.mainTables, o.otherDBTables)
+	}
+	if cols := o.requiredColumns["Users"]; !slices.Equal(cols, []string{"ID"}) {
+		t.Errorf("-exact-case columns = %v", o.requiredColumns)
+	}
+	if o.dialTimeout != time.Second || o.tlsOpts.sslMode != "disable" {
+		t.Errorf("dial timeout, sslmode = %s, %q", o.dialTimeout, o.tlsOpts.sslMode)
+	}
+	if !o.given["tables"] || o.given["timeout"] {
+		t.Errorf("given = %v", o.given)
+	}
+
+	// Given but empty: no server error is retried
+	o, err = parseTestOptions(t, "-retry-sqlstates", "")
+	if err != nil || o.retrySQLStateList == nil || len(o.retrySQLStateList) != 0 {
+		t.Errorf("-retry-sqlstates '': list = %#v, %v; want empty", o.retrySQLStateList, err)
+	}
+}
+
+func TestParseOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"-no-such-flag"}, "not defined"},
+		{[]string{"-sslmode", "sometimes"}, "-sslmode 'sometimes'"},
+		{[]string{"-sslcert", "client.crt"}, "-sslcert/-sslkey"},
+		{[]string{"-tables", "db2:"}, "-tables"},
+		{[]string{"-check-query-expect", "42"}, "-check-query-expect: needs -check-query"},
+		{[]string{"-min-migration-version", "v2"}, "-min-migration-version 'v2'"},
+		{[]string{"-migration-table", "versions"}, "needs -min-migration-version"},
+		{[]string{"-socks5", "http://proxy"}, "-socks5"},
+		{[]string{"-socks5", "socks5://proxy", "-tcp-precheck", "1s"}, "-tcp-precheck: can't be combined with -socks5"},
+		{[]string{"-statement-timeout", "10us"}, "-statement-timeout"},
+		{[]string{"-webhook-url", "ftp://hooks"}, "-webhook-url"},
+		{[]string{"-keepalive", "-invert"}, "-keepalive"},
+		{[]string{"-absent", "-invert", "-tables", "users"}, "-absent: can't be combined with -invert"},
+		{[]string{"-invert", "-tables", "users"}, "use -absent"},
+		{[]string{"-absent"}, "-absent: needs -tables or -foreign-tables"},
+		{[]string{"-absent", "-tables", "users", "-require-rows"}, "-require-rows"},
+		{[]string{"-retry-interval", "0s"}, "-retry-interval"},
+		{[]string{"-output", "xml"}, "-output 'xml'"},
+		{[]string{"-log-level", "trace"}, "-log-level 'trace'"},
+		{[]string{"-verbose", "-quiet"}, "-verbose: can't be combined with -quiet"},
+		{[]string{"-verbose", "-log-level", "warn"}, "-verbose: can't be combined with -log-level warn"},
+		{[]string{"-template", "{{.Ready}}", "-output", "json"}, "-template: can't be combined with -output"},
+		{[]string{"-template", "{{.Ready"}, "-template"},
+		{[]string{"-retry-sqlstates", "57P0"}, "-retry-sqlstates '57P0'"},
+		{[]string{"-check-concurrency", "0"}, "-check-concurrency 0"},
+		{[]string{"-count", "2", "-no-wait"}, "-count: can't require 2 successes with -no-wait"},
+		{[]string{"-require-primary", "-require-replica"}, "-require-primary"},
+		{[]string{"-max-lock-age", "1m"}, "-max-lock-age: needs -tables"},
+		{[]string{"-require-wal-level", "full"}, "-require-wal-level 'full'"},
+		{[]string{"-require-wal-level", "hot_standby"}, "must be minimal, replica or logical"},
+		{[]string{"-require-wal-level", "archive"}, "must be minimal, replica or logical"},
+		{[]string{"-exit-check-failed", "256"}, "exit code: -exit-check-failed must be between 0 and 255"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if _, err := parseTestOptions(t, tt.args...); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseOptions(%q) error = %v, want one mentioning %q", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseOptionsEnvironment(t *testing.T) {
+	t.Setenv("PGPORT", "fivefourthreetwo")
+	if _, err := parseTestOptions(t); err == nil || !strings.Contains(err.Error(), "PGPORT") {
+		t.Errorf("bad PGPORT: error = %v", err)
+	}
+	if _, err := parseTestOptions(t, "-port", "5433"); err != nil {
+		t.Errorf("bad PGPORT overridden by -port: error = %v", err)
+	}
+	t.Setenv("PGPORT", "5432")
+
+	t.Setenv("PGCONNECT_TIMEOUT", "0")
+	if _, err := parseTestOptions(t); err == nil || !strings.Contains(err.Error(), "PGCONNECT_TIMEOUT: must be at least 1 second") {
+		t.Errorf("PGCONNECT_TIMEOUT=0: error = %v", err)
+	}
+	if _, err := parseTestOptions(t, "-conn-timeout", "3s"); err != nil {
+		t.Errorf("PGCONNECT_TIMEOUT=0 overridden by -conn-timeout: error = %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// pgpassPath is the password file libpq would use: PGPASSFILE, or ~/.pgpass.
+func pgpassPath() string {
+	if path := os.Getenv("PGPASSFILE"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// lookupPgpass finds the password for a connection in the password file, like libpq: the first
+// line whose hostname:port:database:username fields all match (each may be '*') wins. A file
+// others can read is ignored with a warning, as libpq does. Returns false if there is no match.
+//
+// With several hosts libpq looks each one up in turn, but pgx has a single password for all of
+// them: the first host with a matching line decides, and a different password for a later one is
+// warned about.
+func lookupPgpass(cfg *pgx.ConnConfig, db string) (string, bool) {
+	path := pgpassPath()
+	if path == "" {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false // No password file is the normal case
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		slog.Warn("Password file has group or world access; permissions should be u=rw (0600) or less, ignoring it", "file", path)
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("Can't read password file", "file", path, "error", err)
+		return "", false
+	}
+	var lines [][]pgpassField
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := splitPgpassLine(line); len(fields) == 5 {
+			lines = append(lines, fields)
+		} // Otherwise malformed, libpq skips it too
+	}
+
+	hosts := []*pgconn.FallbackConfig{{Host: cfg.Host, Port: cfg.Port}}
+	hosts = append(hosts, cfg.Fallbacks...)
+	password, found := "", false
+	for _, h := range hosts {
+		p, ok := matchPgpass(lines, h.Host, int(h.Port), db, cfg.User)
+		switch {
+		case !ok:
+		case !found:
+			password, found = p, true
+		case p != password:
+			slog.Warn("Password file has different passwords for the hosts; using the first host's for all of them", "file", path, "host", h.Host)
+		}
+	}
+	return password, found
+}
+
+// matchPgpass returns the password of the first of lines that matches the connection.
+func matchPgpass(lines [][]pgpassField, host string, port int, db, user string) (string, bool) {
+	// Socket connections match "localhost", like in libpq.
+	if host == "" || strings.HasPrefix(host, "/") {
+		host = "localhost"
+	}
+	want := []string{host, strconv.Itoa(port), db, user}
+	for _, fields := range lines {
+		matches := true
+		for i, w := range want {
+			if !fields[i].wildcard && fields[i].value != w {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return fields[4].value, true
+		}
+	}
+	return "", false
+}
+
+// pgpassField is a field of a password file line.
+type pgpassField struct {
+	value    string
+	wildcard bool // A bare "*", which matches anything; "\*" is a literal asterisk
+}
+
+// splitPgpassLine splits a password file line on its colons. A backslash escapes the next
+// character, so "\:" is a literal colon and "\\" a backslash.
+func splitPgpassLine(line string) []pgpassField {
+	var fields []pgpassField
+	var field strings.Builder
+	escaped, anyEscaped := false, false
+	flush := func() {
+		fields = append(fields, pgpassField{value: field.String(), wildcard: field.String() == "*" && !anyEscaped})
+		field.Reset()
+		anyEscaped = false
+	}
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, anyEscaped = true, true
+		case r == ':' && len(fields) < 4:
+			flush()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	flush()
+	return fields
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// writePgpass writes a password file with the given mode and points PGPASSFILE at it.
+func writePgpass(t *testing.T, content string, mode os.FileMode) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pgpass")
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil { // Not narrowed by the umask
+		t.Fatal(err)
+	}
+	t.Setenv("PGPASSFILE", path)
+}
+
+func pgpassConfig(user string, hosts ...string) *pgx.ConnConfig {
+	cfg := &pgx.ConnConfig{Config: pgconn.Config{Host: hosts[0], Port: 5432, User: user}}
+	for _, h := range hosts[1:] {
+		cfg.Fallbacks = append(cfg.Fallbacks, &pgconn.FallbackConfig{Host: h, Port: 5432})
+	}
+	return cfg
+}
+
+func TestLookupPgpass(t *testing.T) {
+	file := `# comment
+db1:5432:appdb:app:first
+db1:5432:appdb:app:shadowed
+*:5433:*:*:other port
+db\:colon:5432:*:app:colon host
+db2:5432:*:app:pass\:with\\backslash
+\*:5432:*:app:literal asterisk
+*:5432:*:ops:wildcard host
+localhost:5432:*:app:local
+malformed:line
+`
+	tests := []struct {
+		name   string
+		cfg    *pgx.ConnConfig
+		want   string
+		wantOK bool
+	}{
+		{name: "first match wins", cfg: pgpassConfig("app", "db1"), want: "first", wantOK: true},
+		{name: "escaped colon in host", cfg: pgpassConfig("app", "db:colon"), want: "colon host", wantOK: true},
+		{name: "escapes in password", cfg: pgpassConfig("app", "db2"), want: `pass:with\backslash`, wantOK: true},
+		{name: "escaped asterisk is literal", cfg: pgpassConfig("app", "*"), want: "literal asterisk", wantOK: true},
+		{name: "escaped asterisk doesn't match anything", cfg: pgpassConfig("app", "db3"), wantOK: false},
+		{name: "wildcard", cfg: pgpassConfig("ops", "anywhere"), want: "wildcard host", wantOK: true},
+		{name: "socket matches localhost", cfg: pgpassConfig("app", "/var/run/postgresql"), want: "local", wantOK: true},
+		{name: "no match", cfg: pgpassConfig("nobody", "db1"), wantOK: false},
+		{name: "second host", cfg: pgpassConfig("app", "db3", "db2"), want: `pass:with\backslash`, wantOK: true},
+		{name: "first host wins", cfg: pgpassConfig("app", "db1", "db2"), want: "first", wantOK: true},
+	}
+	writePgpass(t, file, 0o600)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := lookupPgpass(tt.cfg, "appdb")
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("lookupPgpass() = %q, %v; want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLookupPgpassPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission check on Windows")
+	}
+	for _, mode := range []os.FileMode{0o640, 0o604, 0o660, 0o644} {
+		writePgpass(t, "*:*:*:*:s3cret\n", mode)
+		if got, ok := lookupPgpass(pgpassConfig("app", "db"), "appdb"); ok {
+			t.Errorf("mode %v: lookupPgpass() = %q, want the file ignored", mode, got)
+		}
+	}
+	writePgpass(t, "*:*:*:*:s3cret\n", 0o400)
+	if got, ok := lookupPgpass(pgpassConfig("app", "db"), "appdb"); !ok || got != "s3cret" {
+		t.Errorf("mode 0400: lookupPgpass() = %q, %v; want s3cret", got, ok)
+	}
+}
+
+func TestSplitPgpassLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []pgpassField
+	}{
+		{line: "h:5432:db:u:pw", want: []pgpassField{{value: "h"}, {value: "5432"}, {value: "db"}, {value: "u"}, {value: "pw"}}},
+		{line: `*:\*:a\:b:\\:p:w`, want: []pgpassField{{value: "*", wildcard: true}, {value: "*"}, {value: "a:b"}, {value: `\`}, {value: "p:w"}}},
+		{line: "a:b", want: []pgpassField{{value: "a"}, {value: "b"}}},
+	}
+	for _, tt := range tests {
+		if got := splitPgpassLine(tt.line); !slices.Equal(got, tt.want) {
+			t.Errorf("splitPgpassLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package readycheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Check is a single condition evaluated on an established connection.
+type Check struct {
+	Name       string
+	Objects    []string // What the check looks at (tables, extensions, ...), if it's per object
+	Privileged bool     // Runs on the admin connection when one is configured
+	Disabled   bool     // Turned off (see DisableChecks); always reported as skipped
+	Blocking   bool     // Waits for an event rather than querying; bounded by Config.Timeout, not QueryTimeout
+	// Run returns nil when the condition is met, an error from NotReady and friends when it
+	// isn't, and any other error when it couldn't tell (e.g. the query failed).
+	Run func(ctx context.Context, conn *pgx.Conn) error
+}
+
+// ObjectProblem is one object that failed a check, with an optional detail like "table missing".
+type ObjectProblem struct {
+	Name   string
+	Detail string
+}
+
+func (p ObjectProblem) String() string {
+	if p.Detail == "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%s (%s)", p.Name, p.Detail)
+}
+
+// MissingObjects wraps plain object names as problems sharing the same detail.
+func MissingObjects(names []string, detail string) []ObjectProblem {
+	problems := make([]ObjectProblem, len(names))
+	for i, name := range names {
+		problems[i] = ObjectProblem{Name: name, Detail: detail}
+	}
+	return problems
+}
+
+// checkFailure reports that a check query ran fine but its condition is not met.
+// Any other error returned by a check means the query itself failed.
+type checkFailure struct {
+	msg     string
+	fatal   bool            // Retrying won't help (e.g. server configuration), fail immediately
+	broken  bool            // The check itself can't run (e.g. lacks privileges), so there's no answer at all
+	summary string          // For per-object checks, the message without the object list
+	objects []ObjectProblem // For per-object checks, the objects that failed
+}
+
+func (e *checkFailure) Error() string {
+	return e.msg
+}
+
+// NotReady returns a check failure that is worth retrying (e.g. tables not created yet).
+func NotReady(format string, args ...interface{}) error {
+	return &checkFailure{msg: fmt.Sprintf(format, args...)}
+}
+
+// Misconfigured returns a check failure that won't change while we wait, so Wait gives up at once.
+func Misconfigured(format string, args ...interface{}) error {
+	return &checkFailure{msg: fmt.Sprintf(format, args...), fatal: true}
+}
+
+// CannotCheck returns a failure for a check that can't run at all, like when the user lacks the
+// privileges its query needs. Retrying won't help, so Wait gives up at once with FailureInternal.
+func CannotCheck(err error) error {
+	return &checkFailure{msg: err.Error(), fatal: true, broken: true}
+}
+
+// ObjectsNotReady returns a retryable check failure listing the objects that failed.
+func ObjectsNotReady(summary string, problems []ObjectProblem) error {
+	return &checkFailure{msg: describeProblems(summary, problems), summary: summary, objects: problems}
+}
+
+// ObjectsMisconfigured returns a fatal check failure listing the objects that failed.
+func ObjectsMisconfigured(summary string, problems []ObjectProblem) error {
+	return &checkFailure{msg: describeProblems(summary, problems), fatal: true, summary: summary, objects: problems}
+}
+
+func describeProblems(summary string, problems []ObjectProblem) string {
+	parts := make([]string, len(problems))
+	for i, p := range problems {
+		parts[i] = p.String()
+	}
+	return summary + ": " + strings.Join(parts, ", ")
+}
+
+// needsPrivileges reports whether any of the checks would use an admin connection.
+func needsPrivileges(checks []Check) bool {
+	for _, c := range checks {
+		if c.Privileged && !c.Disabled {
+			return true
+		}
+	}
+	return false
+}
+
+// anyEnabled reports whether any of checks will run, i.e. isn't disabled.
+func anyEnabled(checks []Check) bool {
+	return slices.ContainsFunc(checks, func(c Check) bool { return !c.Disabled })
+}
+
+// ownPIDsKey is the context key of the backend PIDs OwnBackendPIDs returns.
+type ownPIDsKey struct{}
+
+// OwnBackendPIDs returns the backend PIDs of all the connections Wait holds while a check runs
+// (app, admin and those for concurrent checks), which a check counting connections should leave
+// out. Nil for a ctx that doesn't come from Wait.
+func OwnBackendPIDs(ctx context.Context) []uint32 {
+	pids, _ := ctx.Value(ownPIDsKey{}).([]uint32)
+	return pids
+}
+
+// withOwnBackendPIDs returns ctx carrying the backend PIDs of conns for OwnBackendPIDs.
+// Nil connections are left out.
+func withOwnBackendPIDs(ctx context.Context, conns ...*pgx.Conn) context.Context {
+	var pids []uint32
+	for _, conn := range conns {
+		if conn != nil {
+			pids = append(pids, conn.PgConn().PID())
+		}
+	}
+	return context.WithValue(ctx, ownPIDsKey{}, pids)
+}
+
+// errQueryTimeout marks a check that ran out of Config.QueryTimeout, which is worth retrying.
+var errQueryTimeout = errors.New("query timed out")
+
+// runChecks runs the checks, giving each but the blocking ones its own query timeout.
+// Privileged checks use adminConn if it isn't nil, everything else one of conns.
+// With a single connection the checks run in order, stopping at the first that doesn't pass.
+// With more, the blocking checks still run first, in order, and then the others concurrently,
+// one per connection at a time (and the privileged ones one at a time on adminConn); none is
+// started once one has failed, and the error is that of the first failed check in order.
+// Either way the checks that weren't run are reported as skipped. Disabled checks are never run.
+func runChecks(ctx context.Context, conns []*pgx.Conn, adminConn *pgx.Conn, checks []Check, queryTimeout time.Duration) ([]CheckResult, error) {
+	if len(conns) == 1 {
+		results := skippedResults(checks, "not run: an earlier check failed")
+		for i, c := range checks {
+			if c.Disabled {
+				continue // Already reported as skipped
+			}
+			if err := runCheck(ctx, checkConn(c, conns[0], adminConn), c, queryTimeout, &results[i]); err != nil {
+				return results, err
+			}
+		}
+		return results, nil
+	}
+
+	results := skippedResults(checks, "not run: another check failed")
+	general := make(chan int, len(checks))
+	privileged := make(chan int, len(checks))
+	for i, c := range checks {
+		switch {
+		case c.Disabled:
+		case c.Blocking:
+			// Whatever the others look at may depend on what this waits for.
+			if err := runCheck(ctx, checkConn(c, conns[0], adminConn), c, queryTimeout, &results[i]); err != nil {
+				return results, err
+			}
+		case c.Privileged && adminConn != nil:
+			privileged <- i
+		default:
+			general <- i
+		}
+	}
+	close(general)
+	close(privileged)
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	errs := make([]error, len(checks))
+	worker := func(conn *pgx.Conn, queue <-chan int) {
+		defer wg.Done()
+		for i := range queue {
+			if failed.Load() {
+				continue // Left as skipped
+			}
+			if err := runCheck(ctx, conn, checks[i], queryTimeout, &results[i]); err != nil {
+				errs[i] = err
+				failed.Store(true)
+			}
+		}
+	}
+	for _, conn := range conns {
+		wg.Add(1)
+		go worker(conn, general)
+	}
+	if adminConn != nil {
+		wg.Add(1)
+		go worker(adminConn, privileged)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// checkConn is the connection a check runs on: adminConn for privileged checks, if there is one.
+func checkConn(c Check, conn, adminConn *pgx.Conn) *pgx.Conn {
+	if c.Privileged && adminConn != nil {
+		return adminConn
+	}
+	return conn
+}
+
+// runCheck runs a single check on conn and records its outcome in result. The error, if any, says
+// why it didn't pass.
+func runCheck(ctx context.Context, conn *pgx.Conn, c Check, queryTimeout time.Duration, result *CheckResult) error {
+	started := time.Now()
+	var checkCtx context.Context
+	var cancel context.CancelFunc
+	if c.Blocking {
+		checkCtx, cancel = context.WithCancel(ctx)
+	} else {
+		checkCtx, cancel = context.WithTimeout(ctx, queryTimeout)
+	}
+	err := c.Run(checkCtx, conn)
+	cancel()
+	result.Duration = time.Since(started)
+
+	if err != nil {
+		var failure *checkFailure
+		switch {
+		case errors.As(err, &failure):
+		case errors.Is(checkCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+			// Slow rather than broken (e.g. waiting on a migration's lock), so just retry.
+			err = fmt.Errorf("%s check: %w after %s", c.Name, errQueryTimeout, queryTimeout)
+		default:
+			err = fmt.Errorf("error checking %s: %w", c.Name, err)
+		}
+		result.Status, result.Message = StatusFailed, err.Error()
+		result.Objects = failedObjectResults(c.Objects, failure, err)
+		return err
+	}
+	slog.Debug("Check passed", "check", c.Name, "duration", result.Duration)
+	result.Status, result.Message = StatusPassed, ""
+	result.Objects = uniformObjectResults(c.Objects, StatusPassed, "")
+	return nil
+}
+
+// disabledReason is the skip message of disabled checks.
+const disabledReason = "disabled"
+
+// DisableChecks marks the checks with the given names (case-insensitive) as disabled.
+// Returns the names that don't match any of the checks.
+func DisableChecks(checks []Check, names []string) (unknown []string) {
+	for _, name := range names {
+		found := false
+		for i := range checks {
+			if strings.EqualFold(checks[i].Name, name) {
+				checks[i].Disabled = true
+				found = true
+			}
+		}
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// --- Results ---
+
+// Status is the outcome of a single check in the most recent attempt.
+type Status string
+
+const (
+	StatusPassed  Status = "passed"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+)
+
+// CheckResult records how a check (or the connection itself) fared in the most recent attempt.
+type CheckResult struct {
+	Name     string         `json:"name"`
+	Status   Status         `json:"status"`
+	Message  string         `json:"message,omitempty"` // Failure or skip reason, empty when passed
+	Duration time.Duration  `json:"-"`
+	Objects  []ObjectResult `json:"objects,omitempty"` // Per-object outcome, for checks of named objects
+}
+
+// ObjectResult is the outcome for one object (table, extension, ...) of a check.
+type ObjectResult struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// FailedObjects returns the names of the objects that failed the check (e.g. the missing tables),
+// in order. It is empty when the check passed, was skipped, or isn't about named objects.
+func (r CheckResult) FailedObjects() []string {
+	var failed []string
+	for _, o := range r.Objects {
+		if o.Status == StatusFailed {
+			failed = append(failed, o.Name)
+		}
+	}
+	return failed
+}
+
+// MarshalJSON adds the duration in milliseconds, which is friendlier than nanoseconds.
+func (r CheckResult) MarshalJSON() ([]byte, error) {
+	type plain CheckResult // Drops the method so we don't recurse
+	return json.Marshal(struct {
+		plain
+		DurationMs int64 `json:"duration_ms"`
+	}{plain(r), r.Duration.Milliseconds()})
+}
+
+// skippedResults returns a skipped result for every check, e.g. when we couldn't connect.
+func skippedResults(checks []Check, reason string) []CheckResult {
+	results := make([]CheckResult, len(checks))
+	for i, c := range checks {
+		checkReason := reason
+		if c.Disabled {
+			checkReason = disabledReason
+		}
+		results[i] = CheckResult{Name: c.Name, Status: StatusSkipped, Message: checkReason}
+		results[i].Objects = uniformObjectResults(c.Objects, StatusSkipped, checkReason)
+	}
+	return results
+}
+
+// uniformObjectResults gives every object of a check the same status.
+func uniformObjectResults(objects []string, status Status, message string) []ObjectResult {
+	if len(objects) == 0 {
+		return nil
+	}
+	results := make([]ObjectResult, len(objects))
+	for i, name := range objects {
+		results[i] = ObjectResult{Name: name, Status: status, Message: message}
+	}
+	return results
+}
+
+// failedObjectResults works out the per-object outcome of a failed check. If the failure names the
+// objects that failed, the others passed; otherwise (e.g. the query itself failed) they all failed.
+func failedObjectResults(objects []string, failure *checkFailure, err error) []ObjectResult {
+	if failure == nil || len(failure.objects) == 0 {
+		return uniformObjectResults(objects, StatusFailed, err.Error())
+	}
+	results := uniformObjectResults(objects, StatusPassed, "")
+	for _, p := range failure.objects {
+		message := p.Detail
+		if message == "" {
+			message = failure.summary
+		}
+		idx := slices.IndexFunc(results, func(r ObjectResult) bool { return r.Name == p.Name })
+		if idx == -1 {
+			results = append(results, ObjectResult{Name: p.Name, Status: StatusFailed, Message: message})
+			continue
+		}
+		results[idx].Status, results[idx].Message = StatusFailed, message
+	}
+	return results
+}
@@ -0,0 +1,226 @@
+package readycheck
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Distinct connections for runChecks, which the fake checks only compare. They're never used.
+var (
+	appConn   = new(pgx.Conn)
+	extraConn = new(pgx.Conn)
+	adminConn = new(pgx.Conn)
+)
+
+func resultStatuses(results []CheckResult) []Status {
+	statuses := make([]Status, len(results))
+	for i, r := range results {
+		statuses[i] = r.Status
+	}
+	return statuses
+}
+
+func TestRunChecksInOrder(t *testing.T) {
+	var order []string
+	check := func(name string, err error) Check {
+		return Check{Name: name, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			order = append(order, name)
+			return err
+		}}
+	}
+	disabled := check("disabled", nil)
+	disabled.Disabled = true
+	checks := []Check{check("a", nil), disabled, check("b", NotReady("b not ready")), check("c", nil)}
+
+	results, err := runChecks(context.Background(), []*pgx.Conn{appConn}, nil, checks, time.Second)
+	if err == nil || err.Error() != "b not ready" {
+		t.Errorf("runChecks() error = %v, want b's", err)
+	}
+	if !slices.Equal(order, []string{"a", "b"}) {
+		t.Errorf("ran %v, want a and b, stopping at the first failure", order)
+	}
+	want := []Status{StatusPassed, StatusSkipped, StatusFailed, StatusSkipped}
+	if got := resultStatuses(results); !slices.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	if results[1].Message != disabledReason || results[3].Message != "not run: an earlier check failed" {
+		t.Errorf("skip messages = %q, %q", results[1].Message, results[3].Message)
+	}
+}
+
+func TestRunChecksConcurrently(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	var adminRuns atomic.Int32
+	check := func(name string, privileged bool) Check {
+		return Check{Name: name, Privileged: privileged, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			if privileged {
+				if conn != adminConn {
+					t.Errorf("privileged check %s didn't run on the admin connection", name)
+				}
+				adminRuns.Add(1)
+			} else if conn != appConn && conn != extraConn {
+				t.Errorf("check %s didn't run on an app connection", name)
+			}
+			n := running.Add(1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		}}
+	}
+	checks := []Check{check("a", false), check("b", false), check("c", false), check("d", false), check("admin", true)}
+
+	results, err := runChecks(context.Background(), []*pgx.Conn{appConn, extraConn}, adminConn, checks, time.Second)
+	if err != nil {
+		t.Fatalf("runChecks() error = %v", err)
+	}
+	if got := resultStatuses(results); !slices.Equal(got, []Status{StatusPassed, StatusPassed, StatusPassed, StatusPassed, StatusPassed}) {
+		t.Errorf("statuses = %v, want all passed", got)
+	}
+	// Two app connections and the admin one
+	if maxRunning.Load() < 2 || maxRunning.Load() > 3 {
+		t.Errorf("at most %d checks ran at once, want 2 or 3", maxRunning.Load())
+	}
+	if adminRuns.Load() != 1 {
+		t.Errorf("admin check ran %d times, want 1", adminRuns.Load())
+	}
+}
+
+func TestRunChecksConcurrentFailure(t *testing.T) {
+	var ran atomic.Int32
+	pass := Check{Name: "pass", Run: func(ctx context.Context, conn *pgx.Conn) error { ran.Add(1); return nil }}
+	checks := []Check{
+		pass,
+		{Name: "slow failure", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			time.Sleep(20 * time.Millisecond)
+			return NotReady("slow")
+		}},
+		{Name: "fast failure", Run: func(ctx context.Context, conn *pgx.Conn) error { return NotReady("fast") }},
+	}
+	_, err := runChecks(context.Background(), []*pgx.Conn{appConn, extraConn}, nil, checks, time.Second)
+	// Both fail, but the error is the first failed one's in order, not the first to finish.
+	if err == nil || err.Error() != "slow" {
+		t.Errorf("runChecks() error = %v, want the slow check's", err)
+	}
+}
+
+func TestRunChecksResultOrder(t *testing.T) {
+	// Each check takes less time than the one before, so they finish in reverse order.
+	var checks []Check
+	var names []string
+	for i, name := range []string{"a", "b", "c", "d"} {
+		delay := time.Duration(4-i) * 10 * time.Millisecond
+		checks = append(checks, Check{Name: name, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			time.Sleep(delay)
+			return nil
+		}})
+		names = append(names, name)
+	}
+	results, err := runChecks(context.Background(), []*pgx.Conn{appConn, extraConn, new(pgx.Conn), new(pgx.Conn)}, nil, checks, time.Second)
+	if err != nil {
+		t.Fatalf("runChecks() error = %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Name)
+	}
+	if !slices.Equal(got, names) {
+		t.Errorf("results in order %v, want %v", got, names)
+	}
+}
+
+func TestRunChecksBlockingFirst(t *testing.T) {
+	var blockingDone atomic.Bool
+	checks := []Check{
+		{Name: "a", Run: func(ctx context.Context, conn *pgx.Conn) error {
+			if !blockingDone.Load() {
+				t.Error("check a ran before the blocking check finished")
+			}
+			return nil
+		}},
+		{Name: "notification", Blocking: true, Run: func(ctx context.Context, conn *pgx.Conn) error {
+			time.Sleep(20 * time.Millisecond)
+			blockingDone.Store(true)
+			return nil
+		}},
+	}
+	if _, err := runChecks(context.Background(), []*pgx.Conn{appConn, extraConn}, nil, checks, time.Second); err != nil {
+		t.Fatalf("runChecks() error = %v", err)
+	}
+}
+
+func TestRunChecksQueryTimeout(t *testing.T) {
+	waitForCancel := func(ctx context.Context, conn *pgx.Conn) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	results, err := runChecks(context.Background(), []*pgx.Conn{appConn}, nil,
+		[]Check{{Name: "slow", Objects: []string{"users"}, Run: waitForCancel}}, 10*time.Millisecond)
+	if !errors.Is(err, errQueryTimeout) {
+		t.Errorf("runChecks() error = %v, want a query timeout", err)
+	}
+	if results[0].Status != StatusFailed || results[0].Objects[0].Status != StatusFailed {
+		t.Errorf("result = %+v, want failed with the object failed", results[0])
+	}
+
+	// A blocking check isn't bounded by the query timeout, only by its context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err = runChecks(ctx, []*pgx.Conn{appConn}, nil, []Check{{Name: "notification", Blocking: true, Run: waitForCancel}}, 10*time.Millisecond)
+	if errors.Is(err, errQueryTimeout) || time.Since(started) < 50*time.Millisecond {
+		t.Errorf("blocking check ended after %s with %v, want it to run until the context ends", time.Since(started), err)
+	}
+}
+
+func TestRunChecksObjectResults(t *testing.T) {
+	checks := []Check{{Name: "tables", Objects: []string{"users", "orders", "events"}, Run: func(ctx context.Context, conn *pgx.Conn) error {
+		return ObjectsNotReady("required tables missing", []ObjectProblem{{Name: "orders"}, {Name: "events", Detail: "empty"}})
+	}}}
+	results, _ := runChecks(context.Background(), []*pgx.Conn{appConn}, nil, checks, time.Second)
+	want := []ObjectResult{
+		{Name: "users", Status: StatusPassed},
+		{Name: "orders", Status: StatusFailed, Message: "required tables missing"},
+		{Name: "events", Status: StatusFailed, Message: "empty"},
+	}
+	if !slices.Equal(results[0].Objects, want) {
+		t.Errorf("objects = %+v, want %+v", results[0].Objects, want)
+	}
+	if got := results[0].FailedObjects(); !slices.Equal(got, []string{"orders", "events"}) {
+		t.Errorf("FailedObjects() = %v", got)
+	}
+	if results[0].Message != "required tables missing: orders, events (empty)" {
+		t.Errorf("message = %q", results[0].Message)
+	}
+}
+
+func TestDisableChecks(t *testing.T) {
+	checks := []Check{{Name: "tables"}, {Name: "table bloat"}, {Name: "default privileges"}}
+	unknown := DisableChecks(checks, []string{"Table Bloat", "tables", "no such check"})
+	if !slices.Equal(unknown, []string{"no such check"}) {
+		t.Errorf("DisableChecks() unknown = %v, want [no such check]", unknown)
+	}
+	var disabled []string
+	for _, c := range checks {
+		if c.Disabled {
+			disabled = append(disabled, c.Name)
+		}
+	}
+	if !slices.Equal(disabled, []string{"tables", "table bloat"}) {
+		t.Errorf("disabled %v, want tables and table bloat", disabled)
+	}
+	if needsPrivileges([]Check{{Name: "bloat", Privileged: true, Disabled: true}}) {
+		t.Error("needsPrivileges() counted a disabled check")
+	}
+}
@@ -0,0 +1,43 @@
+package readycheck_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alchen99/pg_ready_check/readycheck"
+	"github.com/jackc/pgx/v5"
+)
+
+func ExampleWait() {
+	connConfig, err := pgx.ParseConfig(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	res, err := readycheck.Wait(context.Background(), readycheck.Config{
+		ConnConfig: connConfig,
+		Checks:     []readycheck.Check{readycheck.TablesCheck([]string{"users", "billing.invoices"}, "public")},
+		Timeout:    2 * time.Minute,
+	})
+	if err != nil {
+		fmt.Printf("database not ready after %d attempts (%s): %v\n", res.Attempts, res.Failure, err)
+		return
+	}
+	fmt.Println("ready on", res.Server)
+}
+
+func ExampleMustWaitForReady() {
+	connConfig, err := pgx.ParseConfig(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		panic(err)
+	}
+	// Exits if the database isn't ready within a minute.
+	readycheck.MustWaitForReady(context.Background(), readycheck.Config{
+		ConnConfig: connConfig,
+		Checks:     []readycheck.Check{readycheck.TablesCheck([]string{"users"}, "public")},
+		Timeout:    time.Minute,
+	})
+	fmt.Println("starting up")
+}
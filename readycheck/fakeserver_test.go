@@ -0,0 +1,173 @@
+package readycheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// fakeServer is a stand-in PostgreSQL server for the tests. It accepts any connection without
+// asking for a password and answers simple queries (like the ping) with an empty result and
+// everything else with an error. That's all it takes for the prober to connect and run the
+// checks, which in the tests don't query anything.
+type fakeServer struct {
+	ln      net.Listener
+	nextPID atomic.Uint32
+	wg      sync.WaitGroup
+}
+
+// startFakeServer starts a fakeServer on a local port, stopped when the test ends.
+func startFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %v", err)
+	}
+	s := &fakeServer{ln: ln}
+	s.nextPID.Store(1000)
+	var mu sync.Mutex
+	var conns []net.Conn
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer conn.Close()
+				s.serve(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// connConfig returns a config that connects to the server.
+func (s *fakeServer) connConfig(t *testing.T) *pgx.ConnConfig {
+	t.Helper()
+	cfg, err := pgx.ParseConfig("postgres://test@" + s.ln.Addr().String() + "/test?sslmode=disable")
+	if err != nil {
+		t.Fatalf("can't parse config: %v", err)
+	}
+	return cfg
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	backend := pgproto3.NewBackend(conn, conn)
+	msg, err := backend.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+	if _, ok := msg.(*pgproto3.StartupMessage); !ok {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "server_version", Value: "16.0"})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: s.nextPID.Add(1), SecretKey: 1})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if err := backend.Flush(); err != nil {
+		return
+	}
+
+	failed := false // In the extended protocol, the rest up to the Sync is skipped after an error
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		switch msg.(type) {
+		case *pgproto3.Query:
+			backend.Send(&pgproto3.EmptyQueryResponse{})
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto3.Sync:
+			failed = false
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto3.Terminate:
+			return
+		default:
+			if !failed {
+				backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "0A000", Message: "not supported by the fake server"})
+				failed = true
+			}
+		}
+		if err := backend.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// errRefused is what a connect to a server that isn't listening yet fails with.
+var errRefused = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+// errAuth is what a connect with the wrong password fails with.
+var errAuth = &pgconn.PgError{Severity: "FATAL", Code: "28P01", Message: "password authentication failed for user \"test\""}
+
+// stubConnect is a connect for wait that fails with the given errors, one per call, and then
+// connects for real (to a fakeServer, normally). It counts its calls.
+type stubConnect struct {
+	failures []error
+	calls    atomic.Int32
+}
+
+func (s *stubConnect) connect(ctx context.Context, config *pgx.ConnConfig, ping bool) (*pgx.Conn, error) {
+	n := int(s.calls.Add(1))
+	if n <= len(s.failures) {
+		return nil, s.failures[n-1]
+	}
+	return connectDB(ctx, config, ping)
+}
+
+// alwaysFail returns n copies of err, for a stubConnect that never gets to connect in a test.
+func alwaysFail(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// countingCheck returns a check that fails with the given errors, one per run, and then passes.
+// runs counts how often it ran.
+func countingCheck(name string, runs *atomic.Int32, failures ...error) Check {
+	return Check{Name: name, Run: func(ctx context.Context, conn *pgx.Conn) error {
+		n := int(runs.Add(1))
+		if n <= len(failures) {
+			return failures[n-1]
+		}
+		return nil
+	}}
+}
+
+// waitError returns err as an *Error, failing the test if it isn't one.
+func waitError(t *testing.T, err error) *Error {
+	t.Helper()
+	var waitErr *Error
+	if !errors.As(err, &waitErr) {
+		t.Fatalf("error = %v (%T), want an *Error", err, err)
+	}
+	return waitErr
+}
@@ -0,0 +1,353 @@
+package readycheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// prober makes the readiness attempts, keeping what it needs from one attempt to the next.
+type prober struct {
+	connConfig      *pgx.ConnConfig
+	adminConnConfig *pgx.ConnConfig // Nil without an admin user
+	checks          []Check
+	connTimeout     time.Duration // Each connection attempt, and the ping of a kept connection
+	queryTimeout    time.Duration // Each check
+	invert          bool          // Waiting for the database to stop accepting connections
+	reuseConn       bool          // Keep the connections, and the checks passed on them, across attempts
+	skipPing        bool          // A successful connect is enough; don't ping
+	retrySQLStates  []string      // See Config.RetrySQLStates
+	concurrency     int           // Checks to run at once, each on a connection of its own
+	tcpPrecheck     time.Duration // See Config.TCPPrecheck; zero connects right away
+
+	// connect opens (and, if ping is set, pings) a connection; connectDB but in tests.
+	connect func(ctx context.Context, config *pgx.ConnConfig, ping bool) (*pgx.Conn, error)
+
+	// Outcome of the most recent attempt
+	attempt      int
+	server       string      // Address of the server last connected to, which of several hosts it was
+	serverInfo   *ServerInfo // From the last successful attempt, if it could be read
+	connResult   CheckResult
+	checkResults []CheckResult
+
+	// Connections kept across attempts with Config.ReuseConnection, and how many of the checks
+	// (in order) have passed on them so far and needn't run again.
+	conn, adminConn *pgx.Conn
+	extraConns      []*pgx.Conn // More app connections, for running checks concurrently
+	checksPassed    int
+}
+
+// close closes any kept connections.
+func (p *prober) close() {
+	if p.conn != nil {
+		p.conn.Close(context.Background())
+	}
+	if p.adminConn != nil {
+		p.adminConn.Close(context.Background())
+	}
+	for _, conn := range p.extraConns {
+		conn.Close(context.Background())
+	}
+	p.conn, p.adminConn, p.extraConns, p.checksPassed = nil, nil, nil, 0
+}
+
+// openExtraConns opens as many more app connections as the concurrency and the checks left to
+// run can use, keeping those from the last attempt that are still open. Running the checks on
+// fewer connections still works, so failing to open one is only a warning.
+func (p *prober) openExtraConns(ctx context.Context, checks []Check) {
+	concurrent := 0 // Checks that would run on the app connections at the same time
+	for _, c := range checks {
+		if !c.Disabled && !c.Blocking && (!c.Privileged || p.adminConn == nil) {
+			concurrent++
+		}
+	}
+	p.extraConns = slices.DeleteFunc(p.extraConns, func(conn *pgx.Conn) bool { return conn.IsClosed() })
+	for len(p.extraConns) < min(p.concurrency, concurrent)-1 {
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, p.connTimeout)
+		conn, err := p.connect(attemptCtx, p.connConfig, !p.skipPing)
+		cancelAttempt()
+		if err != nil {
+			slog.Warn("Could not open another connection for concurrent checks, using fewer", "attempt", p.attempt, "connections", len(p.extraConns)+1, "error", err)
+			return
+		}
+		p.extraConns = append(p.extraConns, conn)
+	}
+}
+
+// runOnce makes one attempt: connect (or make sure the kept connection still works), then run
+// the checks that haven't passed yet. It returns no error once ready. Otherwise the error says
+// what isn't ready yet, and the failure is what to give up with.
+func (p *prober) runOnce(ctx context.Context) (Failure, error) {
+	p.attempt++
+	if p.conn != nil {
+		// Kept from the last attempt; make sure it (and the admin one) didn't die while we waited.
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, p.connTimeout)
+		connStart := time.Now()
+		var err error
+		if p.skipPing {
+			if p.conn.IsClosed() || (p.adminConn != nil && p.adminConn.IsClosed()) {
+				err = errors.New("connection closed")
+			}
+		} else {
+			err = p.conn.Ping(attemptCtx)
+			if err == nil && p.adminConn != nil {
+				err = p.adminConn.Ping(attemptCtx)
+			}
+		}
+		cancelAttempt()
+		p.connResult = CheckResult{Name: "connection", Status: StatusPassed, Duration: time.Since(connStart)}
+		if err != nil {
+			slog.Debug("Kept connection lost, reconnecting", "attempt", p.attempt, "error", err)
+			p.close()
+		}
+	}
+
+	if p.conn == nil {
+		// Try connecting
+		connStart := time.Now()
+		var newConn *pgx.Conn
+		var err error
+		if p.tcpPrecheck > 0 {
+			err = tcpReachable(ctx, p.connConfig, p.tcpPrecheck)
+		}
+		if err == nil {
+			attemptCtx, cancelAttempt := context.WithTimeout(ctx, p.connTimeout)
+			newConn, err = p.connect(attemptCtx, p.connConfig, !p.skipPing)
+			cancelAttempt() // Release context resources promptly
+		}
+		p.connResult = CheckResult{Name: "connection", Status: StatusPassed, Duration: time.Since(connStart)}
+
+		if err != nil {
+			if dnsErr := dnsError(err); dnsErr != nil {
+				// Common while a container network is coming up; not the same as a refused connection.
+				slog.Debug("Host name not resolvable yet", "attempt", p.attempt, "hostname", dnsErr.Name, "error", dnsErr.Err)
+			} else if errors.Is(err, errPortNotOpen) {
+				slog.Debug("Port not open yet", "attempt", p.attempt, "duration", p.connResult.Duration, "error", err)
+			} else {
+				slog.Debug("Connection attempt failed", "attempt", p.attempt, "duration", p.connResult.Duration, "error", err)
+			}
+			err = fmt.Errorf("connection attempt failed: %w", err)
+			p.connResult.Status, p.connResult.Message = StatusFailed, err.Error()
+			p.checkResults = skippedResults(p.checks, "not run: no connection")
+			if p.invert && !pastDeadline(ctx) {
+				// An unreachable database is what we're waiting for. (Not a connect the timeout cut
+				// short, though.)
+				return "", nil
+			}
+			if isAuthError(err) {
+				return FailureAuth, err
+			}
+			return FailureConnection, err
+		}
+
+		// --- Connection Successful ---
+		p.server = newConn.PgConn().Conn().RemoteAddr().String()
+		slog.Debug("Connection successful", "attempt", p.attempt, "duration", p.connResult.Duration, "server", p.server,
+			"server_version", newConn.PgConn().ParameterStatus("server_version"))
+		if p.invert {
+			newConn.Close(context.Background())
+			slog.Debug("Database still accepting connections", "attempt", p.attempt)
+			return FailureCheck, errors.New("database still accepting connections")
+		}
+
+		// --- Privileged Connection (if configured and needed) ---
+		var newAdminConn *pgx.Conn
+		if p.adminConnConfig != nil && needsPrivileges(p.checks) {
+			attemptCtx, cancelAttempt := context.WithTimeout(ctx, p.connTimeout)
+			newAdminConn, err = p.connect(attemptCtx, p.adminConnConfig, !p.skipPing)
+			cancelAttempt()
+
+			if err != nil {
+				newConn.Close(context.Background())
+				slog.Debug("Admin connection attempt failed", "attempt", p.attempt, "error", err)
+				err = fmt.Errorf("admin connection attempt failed: %w", err)
+				p.connResult.Status, p.connResult.Message = StatusFailed, err.Error()
+				p.checkResults = skippedResults(p.checks, "not run: no admin connection")
+				if isAuthError(err) {
+					return FailureAuth, err
+				}
+				return FailureConnection, err
+			}
+		}
+		p.conn, p.adminConn = newConn, newAdminConn
+	}
+
+	// --- Run Readiness Checks ---
+	// On a kept connection, only the checks from the first one that failed last time on.
+	pending := p.checks[p.checksPassed:]
+	if p.concurrency > 1 {
+		p.openExtraConns(ctx, pending)
+	}
+	conns := append([]*pgx.Conn{p.conn}, p.extraConns...)
+	checkCtx := withOwnBackendPIDs(ctx, append(conns, p.adminConn)...)
+	results, err := runChecks(checkCtx, conns, p.adminConn, pending, p.queryTimeout)
+	p.checkResults = append(p.checkResults[:p.checksPassed:p.checksPassed], results...)
+	if err != nil {
+		var failure *checkFailure
+		if errors.As(err, &failure) {
+			slog.Debug("Not ready yet", "attempt", p.attempt, "error", err)
+		} else if errors.Is(err, errQueryTimeout) {
+			slog.Warn("Check timed out", "attempt", p.attempt, "error", err)
+		} else if ctx.Err() == nil {
+			// Error while running a check query (not just an unmet condition). Worth retrying.
+			slog.Error("Check could not run", "attempt", p.attempt, "error", err)
+		}
+		if p.reuseConn {
+			// Run concurrently, a check before the failed one may not have run at all.
+			p.checksPassed += slices.IndexFunc(results, func(r CheckResult) bool {
+				return r.Status == StatusFailed || (r.Status == StatusSkipped && r.Message != disabledReason)
+			})
+		} else {
+			p.close() // Close connections, not ready yet
+		}
+		if failure != nil && failure.broken {
+			return FailureInternal, err
+		}
+		return FailureCheck, err
+	}
+
+	// Best effort: not knowing the version doesn't make the server any less ready.
+	infoCtx, cancelInfo := context.WithTimeout(ctx, p.queryTimeout)
+	if info, err := collectServerInfo(infoCtx, p.conn); err != nil {
+		slog.Warn("Could not read server info", "attempt", p.attempt, "error", err)
+	} else {
+		p.serverInfo = &info
+	}
+	cancelInfo()
+
+	p.close() // Close the successful connections
+	return "", nil
+}
+
+// SQLState returns the SQLSTATE of the PostgreSQL error in err's chain, or "" if the error didn't
+// come from the server (a refused connection, a timeout, an unmet check, ...).
+func SQLState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// isAuthError reports whether the server rejected the credentials: 28P01 for a wrong password,
+// 28000 when pg_hba.conf has no matching entry.
+func isAuthError(err error) bool {
+	code := SQLState(err)
+	return code == "28000" || code == "28P01"
+}
+
+// dnsError returns the host name lookup failure in err's chain, or nil if there is none.
+func dnsError(err error) *net.DNSError {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr
+	}
+	return nil
+}
+
+// IsRetryable reports whether a failed attempt is worth retrying. Errors without a SQLSTATE always
+// are: refused connections, timeouts and DNS failures since the server may still be starting, and
+// unmet checks since that's what waiting is for. So is a DNS failure alongside a server error, as
+// when one of several hosts doesn't resolve yet. Other errors from the server are retried if their
+// SQLSTATE is in retrySQLStates or, when it is nil, unless the server rejected the credentials,
+// which won't fix itself.
+func IsRetryable(err error, retrySQLStates []string) bool {
+	code := SQLState(err)
+	switch {
+	case code == "" || dnsError(err) != nil:
+		return true
+	case retrySQLStates == nil:
+		return !isAuthError(err)
+	default:
+		return slices.Contains(retrySQLStates, code)
+	}
+}
+
+// errPortNotOpen marks a failed TCP precheck: nothing listens on the server's port (yet).
+var errPortNotOpen = errors.New("port not open yet")
+
+// tcpReachable dials the TCP port of each of config's hosts in turn, giving each up to timeout, and
+// returns nil as soon as one accepts the connection, which is closed again right away. It's a cheap
+// way to tell a server that isn't listening yet from one that is, before the full connect and its
+// TLS and startup handshakes. A Unix socket among the hosts is left to the full connect.
+func tcpReachable(ctx context.Context, config *pgx.ConnConfig, timeout time.Duration) error {
+	targets := []*pgconn.FallbackConfig{{Host: config.Host, Port: config.Port}}
+	targets = append(targets, config.Fallbacks...)
+	dialer := &net.Dialer{Timeout: timeout}
+	var lastErr error
+	for _, t := range targets {
+		if strings.HasPrefix(t.Host, "/") {
+			return nil
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(t.Host, strconv.Itoa(int(t.Port))))
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("%w: %w", errPortNotOpen, lastErr)
+}
+
+// connectDB attempts to connect to the database and, if ping is set, pings it. The ping is what
+// proves the server answers queries; the connect alone only proves it completed the startup
+// handshake (which a pooler like pgbouncer does on the server's behalf).
+func connectDB(ctx context.Context, config *pgx.ConnConfig, ping bool) (*pgx.Conn, error) {
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, SanitizeError(err, config.Password)
+	}
+	if !ping {
+		return conn, nil
+	}
+
+	// Ping the database to verify the connection is live
+	if err := conn.Ping(ctx); err != nil {
+		conn.Close(context.Background()) // Close connection if ping fails
+		return nil, fmt.Errorf("failed to ping database: %w", SanitizeError(err, config.Password))
+	}
+
+	return conn, nil
+}
+
+// sanitizedError is an error with secrets masked out of its message.
+// It still unwraps to the original, so errors.Is and errors.As keep working.
+type sanitizedError struct {
+	msg string
+	err error
+}
+
+func (e *sanitizedError) Error() string { return e.msg }
+func (e *sanitizedError) Unwrap() error { return e.err }
+
+// SanitizeError replaces each non-empty secret in err's message with [PASSWORD], both as is and in
+// the forms URL encoding gives it, since pgx may quote the DSN. Returns err itself if nothing matched.
+func SanitizeError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		userInfo := strings.TrimPrefix(url.UserPassword("", secret).String(), ":")
+		for _, form := range []string{secret, userInfo, url.QueryEscape(secret), url.PathEscape(secret)} {
+			msg = strings.ReplaceAll(msg, form, "[PASSWORD]")
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &sanitizedError{msg: msg, err: err}
+}
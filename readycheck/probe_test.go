@@ -0,0 +1,132 @@
+package readycheck
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestSanitizeError(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		msg      string
+		want     string
+	}{
+		{name: "plain", password: "hunter2", msg: "failed to connect to `user=app password=hunter2`", want: "failed to connect to `user=app password=[PASSWORD]`"},
+		{name: "special characters", password: "p@ss:w/rd%#", msg: "bad password p@ss:w/rd%#", want: "bad password [PASSWORD]"},
+		{name: "space", password: "two words", msg: "bad password 'two words'", want: "bad password '[PASSWORD]'"},
+		{
+			name:     "userinfo encoded",
+			password: "p@ss:w/rd%#",
+			msg:      "cannot parse postgres://app:" + strings.TrimPrefix(url.UserPassword("", "p@ss:w/rd%#").String(), ":") + "@db/app",
+			want:     "cannot parse postgres://app:[PASSWORD]@db/app",
+		},
+		{name: "query encoded", password: "a b&c", msg: "cannot parse ?password=" + url.QueryEscape("a b&c"), want: "cannot parse ?password=[PASSWORD]"},
+		{name: "path encoded", password: "a b/c", msg: "cannot parse /" + url.PathEscape("a b/c"), want: "cannot parse /[PASSWORD]"},
+		{name: "every occurrence", password: "s3cret", msg: "s3cret and s3cret", want: "[PASSWORD] and [PASSWORD]"},
+		{name: "no password", password: "", msg: "connection refused", want: "connection refused"},
+		{name: "not in message", password: "s3cret", msg: "connection refused", want: "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := errors.New(tt.msg)
+			err := SanitizeError(fmt.Errorf("wrapped: %w", orig), tt.password)
+			if got := err.Error(); got != "wrapped: "+tt.want {
+				t.Errorf("SanitizeError() = %q, want %q", got, "wrapped: "+tt.want)
+			}
+			if !errors.Is(err, orig) {
+				t.Error("SanitizeError() result no longer wraps the original")
+			}
+		})
+	}
+	if SanitizeError(nil, "s3cret") != nil {
+		t.Error("SanitizeError(nil) != nil")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	wrongPassword := fmt.Errorf("connection attempt failed: %w", errAuth)
+	noHBAEntry := &pgconn.PgError{Severity: "FATAL", Code: "28000", Message: "no pg_hba.conf entry"}
+	tests := []struct {
+		name      string
+		err       error
+		wantAuth  bool
+		wantRetry bool
+	}{
+		{name: "refused", err: errRefused, wantRetry: true},
+		{name: "unmet check", err: NotReady("tables missing"), wantRetry: true},
+		{name: "wrong password", err: wrongPassword, wantAuth: true},
+		{name: "no pg_hba.conf entry", err: noHBAEntry, wantAuth: true},
+		{name: "starting up", err: &pgconn.PgError{Code: "57P03"}, wantRetry: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAuthError(tt.err); got != tt.wantAuth {
+				t.Errorf("isAuthError() = %v, want %v", got, tt.wantAuth)
+			}
+			if got := IsRetryable(tt.err, nil); got != tt.wantRetry {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestIsRetryableSQLStates(t *testing.T) {
+	startingUp := &pgconn.PgError{Code: "57P03"}
+	noDatabase := &pgconn.PgError{Code: "3D000"}
+	tests := []struct {
+		name   string
+		err    error
+		states []string
+		want   bool
+	}{
+		{name: "listed", err: startingUp, states: []string{"57P03", "3D000"}, want: true},
+		{name: "not listed", err: noDatabase, states: []string{"57P03"}},
+		{name: "wrapped", err: fmt.Errorf("connection attempt failed: %w", noDatabase), states: []string{"3D000"}, want: true},
+		{name: "rejected credentials listed", err: errAuth, states: []string{"28P01"}, want: true},
+		{name: "empty list retries nothing from the server", err: startingUp, states: []string{}},
+		{name: "no sqlstate", err: errRefused, states: []string{"57P03"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err, tt.states); got != tt.want {
+				t.Errorf("IsRetryable(%v, %v) = %v, want %v", tt.err, tt.states, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryableDNS(t *testing.T) {
+	notFound := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "db", IsNotFound: true}}
+	// One host of several doesn't resolve yet, and another one turned the connection down
+	alongsideServerError := errors.Join(notFound, &pgconn.PgError{Code: "3D000"})
+	tests := []struct {
+		name   string
+		err    error
+		states []string
+	}{
+		{name: "not found", err: notFound},
+		{name: "wrapped", err: fmt.Errorf("connection attempt failed: %w", notFound)},
+		{name: "sqlstates listed", err: notFound, states: []string{"57P03"}},
+		{name: "alongside a server error", err: alongsideServerError, states: []string{"57P03"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if dnsError(tt.err) == nil {
+				t.Errorf("dnsError(%v) = nil", tt.err)
+			}
+			if !IsRetryable(tt.err, tt.states) {
+				t.Errorf("IsRetryable(%v, %v) = false, want true", tt.err, tt.states)
+			}
+		})
+	}
+	if dnsError(errRefused) != nil {
+		t.Error("dnsError() found a lookup failure in a refused connection")
+	}
+}
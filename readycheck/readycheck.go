@@ -0,0 +1,310 @@
+// Package readycheck waits for a PostgreSQL database to be ready: accepting connections and,
+// optionally, passing a list of checks (tables exist, extensions installed, ...).
+//
+// It is the engine behind the pg_ready_check command, for programs that would rather wait for
+// their database in-process than shell out:
+//
+//	connConfig, _ := pgx.ParseConfig(os.Getenv("DATABASE_URL"))
+//	res, err := readycheck.Wait(ctx, readycheck.Config{
+//		ConnConfig: connConfig,
+//		Checks:     []readycheck.Check{readycheck.TablesCheck([]string{"users"}, "public")},
+//		Timeout:    time.Minute,
+//	})
+//
+// MustWaitForReady does the same for a program's startup, which can't go on without its
+// database anyway: it exits through log.Fatal if the database isn't ready in time.
+//
+// Wait logs its progress through the default slog logger.
+package readycheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Defaults for the Config fields left zero.
+const (
+	DefaultConnTimeout   = 5 * time.Second // Each connection attempt
+	DefaultRetryInterval = 1 * time.Second // Wait time between attempts
+)
+
+// Config says what to connect to, what to check, and how long to keep trying.
+type Config struct {
+	ConnConfig      *pgx.ConnConfig
+	AdminConnConfig *pgx.ConnConfig // Optional; runs the privileged checks
+	Checks          []Check
+
+	Timeout       time.Duration // Overall limit on waiting; zero waits as long as ctx allows
+	ConnTimeout   time.Duration // Each connection attempt, and the ping of a kept connection
+	QueryTimeout  time.Duration // Each check; defaults to ConnTimeout
+	RetryInterval time.Duration // Wait time between attempts
+
+	Invert          bool // Wait for the database to stop accepting connections instead; no check may be enabled
+	ReuseConnection bool // Keep the connections, and the checks passed on them, across attempts
+	NoRetryOnChecks bool // Give up as soon as a check fails, instead of only on misconfiguration
+	SingleAttempt   bool // Make one attempt and report its outcome, like pg_isready
+	SuccessCount    int  // Consecutive successful attempts needed to be ready; defaults to 1
+	SkipPing        bool // Count a completed connect as success without pinging the server
+	MaxAttempts     int  // Give up after this many failed attempts, even before Timeout; zero is unlimited
+
+	// RetrySQLStates lists the SQLSTATEs worth retrying when a connection or check query fails;
+	// any other error from the server gives up at once. Nil retries all but rejected credentials.
+	// Errors without a SQLSTATE, like a refused connection, are always retried (see IsRetryable).
+	RetrySQLStates []string
+
+	// CheckConcurrency is how many checks may run at once, each on an app connection of its own
+	// (privileged ones take turns on the admin connection). Defaults to 1: in order, on one.
+	CheckConcurrency int
+
+	// TCPPrecheck, if positive, first dials the server's TCP port with this timeout on each attempt,
+	// and skips the full connect while nothing accepts there yet. Leave it zero with a custom
+	// ConnConfig.DialFunc (e.g. a proxy), which the precheck doesn't go through.
+	TCPPrecheck time.Duration
+}
+
+// Failure says why Wait gave up. It is empty when the database is ready.
+type Failure string
+
+const (
+	FailureConnection  Failure = "connection" // Couldn't connect
+	FailureAuth        Failure = "auth"       // The server rejected the credentials; not retried
+	FailureCheck       Failure = "check"      // Connected, but a check didn't pass
+	FailureInternal    Failure = "internal"   // A check couldn't run at all (see CannotCheck)
+	FailureInterrupted Failure = "interrupted"
+)
+
+// Result is the outcome of Wait, with how the most recent attempt went (or, if the timeout cut
+// that one short, the one before).
+type Result struct {
+	Ready      bool
+	Failure    Failure
+	Attempts   int
+	Duration   time.Duration
+	Server     string      // Address of the server last connected to, which of several hosts it was
+	ServerInfo *ServerInfo // Version and recovery state, when ready and they could be read
+	Connection CheckResult
+	Checks     []CheckResult
+}
+
+// Error is the error Wait returns when the database isn't ready, so callers can tell what failed
+// with errors.As instead of parsing the message, which is that of Err.
+type Error struct {
+	Failure Failure
+	Check   string   // Name of the check that failed, if it came to one ("connection" if it didn't connect)
+	Objects []string // The objects that check reported failed, e.g. the missing tables
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wait connects and runs the checks until they all pass in SuccessCount attempts in a row,
+// retrying every RetryInterval until Config.Timeout runs out or MaxAttempts have failed. A check
+// reporting Misconfigured or CannotCheck (FailureInternal), or an error IsRetryable turns down (by
+// default the server rejecting the credentials, FailureAuth), stops it early. Cancelling ctx gives
+// up with FailureInterrupted.
+//
+// The error is nil exactly when the result is ready, and otherwise an *Error saying what wasn't.
+func Wait(ctx context.Context, cfg Config) (Result, error) {
+	return wait(ctx, cfg, connectDB)
+}
+
+// MustWaitForReady is Wait for a program that can't start without its database: it returns once
+// the database is ready and otherwise exits through log.Fatal, saying why it gave up.
+func MustWaitForReady(ctx context.Context, cfg Config) Result {
+	res, err := Wait(ctx, cfg)
+	if err != nil {
+		log.Fatalf("database not ready after %d attempts: %v", res.Attempts, err)
+	}
+	return res
+}
+
+// wait is Wait with the function that opens the connections, which tests replace.
+func wait(ctx context.Context, cfg Config, connect func(context.Context, *pgx.ConnConfig, bool) (*pgx.Conn, error)) (Result, error) {
+	if cfg.ConnConfig == nil {
+		return Result{}, errors.New("readycheck: no ConnConfig")
+	}
+	if cfg.Invert && anyEnabled(cfg.Checks) {
+		// Checks can't run on a database that's gone; wait for tables to be dropped with a check instead.
+		return Result{}, errors.New("readycheck: Invert can't be combined with enabled Checks")
+	}
+	if cfg.ConnTimeout <= 0 {
+		cfg.ConnTimeout = DefaultConnTimeout
+	}
+	if cfg.QueryTimeout <= 0 {
+		cfg.QueryTimeout = cfg.ConnTimeout
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultRetryInterval
+	}
+	if cfg.SuccessCount <= 0 {
+		cfg.SuccessCount = 1
+	}
+
+	waitCtx := ctx
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	p := &prober{
+		connConfig:      cfg.ConnConfig,
+		adminConnConfig: cfg.AdminConnConfig,
+		checks:          cfg.Checks,
+		connTimeout:     cfg.ConnTimeout,
+		queryTimeout:    cfg.QueryTimeout,
+		invert:          cfg.Invert,
+		reuseConn:       cfg.ReuseConnection,
+		skipPing:        cfg.SkipPing,
+		retrySQLStates:  cfg.RetrySQLStates,
+		concurrency:     cfg.CheckConcurrency,
+		tcpPrecheck:     cfg.TCPPrecheck,
+		connect:         connect,
+	}
+	defer p.close()
+	startTime := time.Now()
+	var lastErr error
+	var lastFailure Failure // Of lastErr's attempt
+	streak := 0             // Consecutive successful attempts so far
+	failed := 0             // Failed attempts, for MaxAttempts
+
+	result := func(failure Failure) Result {
+		return Result{
+			Ready:      failure == "",
+			Failure:    failure,
+			Attempts:   p.attempt,
+			Duration:   time.Since(startTime),
+			Server:     p.server,
+			ServerInfo: p.serverInfo,
+			Connection: p.connResult,
+			Checks:     p.checkResults,
+		}
+	}
+	// waitRetry pauses between attempts, but not past the timeout or a cancel.
+	waitRetry := func() {
+		select {
+		case <-waitCtx.Done():
+		case <-time.After(cfg.RetryInterval):
+		}
+	}
+	// timedOut is the failure when we run out of time or attempts: a check that never passed
+	// isn't blamed on the connection, even if the timeout cut the next connection attempt short.
+	timedOut := func() Failure {
+		if cfg.Invert && p.connResult.Status == StatusPassed {
+			return FailureCheck // Still accepting connections
+		}
+		if lastFailure == FailureCheck {
+			return FailureCheck // Connected fine, but e.g. the tables never appeared
+		}
+		return FailureConnection
+	}
+	giveUp := func(failure Failure) (Result, error) {
+		if lastErr == nil {
+			lastErr = waitCtx.Err()
+		}
+		res := result(failure)
+		waitErr := &Error{Failure: failure, Err: lastErr}
+		for _, c := range append([]CheckResult{res.Connection}, res.Checks...) {
+			if c.Status == StatusFailed {
+				waitErr.Check, waitErr.Objects = c.Name, c.FailedObjects()
+				break
+			}
+		}
+		return res, waitErr
+	}
+
+	for {
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				slog.Error("Interrupted, giving up", "attempts", p.attempt, "error", lastErr)
+				return giveUp(FailureInterrupted)
+			}
+			slog.Error("Overall timeout exceeded", "timeout", cfg.Timeout, "attempts", p.attempt, "error", lastErr)
+			return giveUp(timedOut())
+		default:
+		}
+
+		prevConn, prevChecks := p.connResult, p.checkResults
+		failure, err := p.runOnce(waitCtx)
+		if err != nil {
+			if pastDeadline(waitCtx) && lastErr != nil {
+				// Cut short by the timeout, which says nothing about the database; the attempt
+				// before does, so that's what we report.
+				p.connResult, p.checkResults = prevConn, prevChecks
+			} else {
+				lastErr, lastFailure = err, failure
+			}
+			if streak > 0 {
+				slog.Warn("Success streak broken, starting over", "attempt", p.attempt, "streak", streak, "count", cfg.SuccessCount, "error", err)
+				streak = 0
+			}
+			if !IsRetryable(err, cfg.RetrySQLStates) {
+				if failure == FailureAuth {
+					// Retrying with the same credentials won't help.
+					slog.Error("Authentication failed", "attempt", p.attempt, "error", err)
+				} else {
+					slog.Error("Not retrying", "attempt", p.attempt, "sqlstate", SQLState(err), "error", err)
+				}
+				return giveUp(failure)
+			}
+			var checkErr *checkFailure
+			if errors.As(err, &checkErr) && (checkErr.fatal || cfg.NoRetryOnChecks) {
+				// Connection works, so this is a definitive answer about the server.
+				slog.Error("Check failed", "attempt", p.attempt, "error", err)
+				return giveUp(failure)
+			}
+			if cfg.SingleAttempt {
+				slog.Error("Not ready", "attempt", p.attempt, "error", err)
+				return giveUp(failure)
+			}
+			failed++
+			if cfg.MaxAttempts > 0 && failed >= cfg.MaxAttempts {
+				slog.Error("Maximum attempts reached", "max_attempts", cfg.MaxAttempts, "attempts", p.attempt, "error", err)
+				return giveUp(timedOut())
+			}
+			waitRetry() // Wait before retrying
+			continue
+		}
+
+		// --- Success ---
+		streak++
+		if streak < cfg.SuccessCount {
+			lastErr = fmt.Errorf("only %d of %d consecutive attempts succeeded", streak, cfg.SuccessCount)
+			slog.Debug("Attempt succeeded, waiting for more in a row", "attempt", p.attempt, "streak", streak, "count", cfg.SuccessCount)
+			waitRetry()
+			continue
+		}
+		duration := time.Since(startTime).Round(time.Millisecond)
+		switch {
+		case cfg.Invert:
+			slog.Info("Database not accepting connections", "attempts", p.attempt, "duration", duration)
+		default:
+			attrs := []any{"attempts", p.attempt, "duration", duration, "server", p.server}
+			if p.serverInfo != nil {
+				attrs = append(attrs, "server_version_num", p.serverInfo.VersionNum, "in_recovery", p.serverInfo.InRecovery)
+			}
+			slog.Info("Database ready", attrs...)
+		}
+		return result(""), nil
+	}
+}
+
+// pastDeadline reports whether ctx is done or its deadline has passed. The latter can come first:
+// a dial cut short by the deadline fails on the dialer's own timer, which may fire before ctx's.
+func pastDeadline(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ctx.Err() != nil || (ok && !time.Now().Before(deadline))
+}
@@ -0,0 +1,406 @@
+package readycheck
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil))) // Wait logs every attempt
+	os.Exit(m.Run())
+}
+
+// testConfig is a Config for the fake server that retries quickly.
+func testConfig(t *testing.T, server *fakeServer, checks ...Check) Config {
+	return Config{
+		ConnConfig:    server.connConfig(t),
+		Checks:        checks,
+		Timeout:       5 * time.Second,
+		ConnTimeout:   time.Second,
+		RetryInterval: time.Millisecond,
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	server := startFakeServer(t)
+	var runs atomic.Int32
+	res, err := Wait(context.Background(), testConfig(t, server, countingCheck("tables", &runs)))
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if !res.Ready || res.Failure != "" || res.Attempts != 1 {
+		t.Errorf("Wait() = ready %v, failure %q, attempts %d; want ready on the first attempt", res.Ready, res.Failure, res.Attempts)
+	}
+	if res.Server != server.ln.Addr().String() {
+		t.Errorf("Server = %q, want %q", res.Server, server.ln.Addr().String())
+	}
+	if res.Connection.Status != StatusPassed || len(res.Checks) != 1 || res.Checks[0].Status != StatusPassed {
+		t.Errorf("Connection = %+v, Checks = %+v; want all passed", res.Connection, res.Checks)
+	}
+	if res.ServerInfo != nil {
+		t.Errorf("ServerInfo = %+v, want nil when it can't be read", res.ServerInfo)
+	}
+}
+
+func TestWaitRetriesUntilChecksPass(t *testing.T) {
+	server := startFakeServer(t)
+	connect := &stubConnect{failures: []error{errRefused}}
+	var runs atomic.Int32
+	check := countingCheck("tables", &runs, NotReady("not yet"), NotReady("still not"))
+
+	res, err := wait(context.Background(), testConfig(t, server, check), connect.connect)
+	if err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+	// One refused connection, two unmet checks, then ready
+	if res.Attempts != 4 || runs.Load() != 3 || connect.calls.Load() != 4 {
+		t.Errorf("attempts = %d, check runs = %d, connects = %d; want 4, 3, 4", res.Attempts, runs.Load(), connect.calls.Load())
+	}
+}
+
+func TestWaitRetriesDNSFailures(t *testing.T) {
+	server := startFakeServer(t)
+	notFound := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "db", IsNotFound: true}}
+	connect := &stubConnect{failures: []error{notFound, notFound}}
+	cfg := testConfig(t, server)
+	cfg.RetrySQLStates = []string{"57P03"} // Doesn't stop retrying errors without a SQLSTATE
+	res, err := wait(context.Background(), cfg, connect.connect)
+	if err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+	if res.Attempts != 3 {
+		t.Errorf("attempts = %d, want 3", res.Attempts)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	server := startFakeServer(t)
+	missing := ObjectsNotReady("required tables missing", MissingObjects([]string{"orders"}, ""))
+	tests := []struct {
+		name        string
+		failures    []error
+		check       Check
+		wantFailure Failure
+		wantCheck   string
+		wantObjects []string
+	}{
+		{
+			name:        "never connects",
+			failures:    alwaysFail(errRefused, 1000),
+			check:       Check{Name: "tables", Run: func(ctx context.Context, conn *pgx.Conn) error { return nil }},
+			wantFailure: FailureConnection,
+			wantCheck:   "connection",
+		},
+		{
+			// The timeout may cut a connection attempt short, but it's the check that never passed.
+			name: "check never passes",
+			check: Check{Name: "tables", Objects: []string{"users", "orders"}, Run: func(ctx context.Context, conn *pgx.Conn) error {
+				return missing
+			}},
+			wantFailure: FailureCheck,
+			wantCheck:   "tables",
+			wantObjects: []string{"orders"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, server, tt.check)
+			cfg.Timeout = 100 * time.Millisecond
+			connect := &stubConnect{failures: tt.failures}
+			res, err := wait(context.Background(), cfg, connect.connect)
+			if res.Ready || res.Failure != tt.wantFailure {
+				t.Errorf("wait() = ready %v, failure %q; want %q", res.Ready, res.Failure, tt.wantFailure)
+			}
+			waitErr := waitError(t, err)
+			if waitErr.Failure != tt.wantFailure || waitErr.Check != tt.wantCheck || !slices.Equal(waitErr.Objects, tt.wantObjects) {
+				t.Errorf("error = %+v, want failure %q, check %q, objects %v", waitErr, tt.wantFailure, tt.wantCheck, tt.wantObjects)
+			}
+		})
+	}
+}
+
+func TestWaitMaxAttempts(t *testing.T) {
+	server := startFakeServer(t)
+	tests := []struct {
+		name        string
+		failures    []error // Of the connect
+		checkErr    error
+		wantFailure Failure
+	}{
+		{name: "never connects", failures: alwaysFail(errRefused, 1000), wantFailure: FailureConnection},
+		{name: "check never passes", checkErr: NotReady("tables missing"), wantFailure: FailureCheck},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs atomic.Int32
+			cfg := testConfig(t, server, countingCheck("tables", &runs, alwaysFail(tt.checkErr, 1000)...))
+			if tt.checkErr == nil {
+				cfg.Checks = nil
+			}
+			cfg.MaxAttempts = 3
+			cfg.Timeout = time.Minute // Long enough that only MaxAttempts stops it
+			connect := &stubConnect{failures: tt.failures}
+			res, err := wait(context.Background(), cfg, connect.connect)
+			if res.Failure != tt.wantFailure || res.Attempts != 3 || connect.calls.Load() != 3 {
+				t.Errorf("wait() = failure %q after %d attempts and %d connects, want %q after 3 and 3",
+					res.Failure, res.Attempts, connect.calls.Load(), tt.wantFailure)
+			}
+			if waitErr := waitError(t, err); waitErr.Failure != tt.wantFailure {
+				t.Errorf("error failure = %q, want %q", waitErr.Failure, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestWaitGivesUpEarly(t *testing.T) {
+	server := startFakeServer(t)
+	tests := []struct {
+		name        string
+		failures    []error // Of the connect
+		checkErr    error
+		modify      func(*Config)
+		wantFailure Failure
+	}{
+		{name: "rejected credentials", failures: []error{errAuth}, wantFailure: FailureAuth},
+		{name: "misconfigured", checkErr: Misconfigured("wal_level is minimal"), wantFailure: FailureCheck},
+		{name: "cannot check", checkErr: CannotCheck(errors.New("permission denied")), wantFailure: FailureInternal},
+		{
+			name:        "no retry on checks",
+			checkErr:    NotReady("tables missing"),
+			modify:      func(cfg *Config) { cfg.NoRetryOnChecks = true },
+			wantFailure: FailureCheck,
+		},
+		{
+			name:        "single attempt",
+			failures:    []error{errRefused},
+			modify:      func(cfg *Config) { cfg.SingleAttempt = true },
+			wantFailure: FailureConnection,
+		},
+		{
+			name:        "sqlstate not listed",
+			failures:    []error{&pgconn.PgError{Severity: "FATAL", Code: "3D000", Message: "database \"test\" does not exist"}},
+			modify:      func(cfg *Config) { cfg.RetrySQLStates = []string{"57P03"} },
+			wantFailure: FailureConnection,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs atomic.Int32
+			cfg := testConfig(t, server, countingCheck("check", &runs, alwaysFail(tt.checkErr, 1000)...))
+			if tt.checkErr == nil {
+				cfg.Checks = nil
+			}
+			if tt.modify != nil {
+				tt.modify(&cfg)
+			}
+			connect := &stubConnect{failures: tt.failures}
+			res, err := wait(context.Background(), cfg, connect.connect)
+			if res.Failure != tt.wantFailure || res.Attempts != 1 {
+				t.Errorf("wait() = failure %q after %d attempts, want %q after 1", res.Failure, res.Attempts, tt.wantFailure)
+			}
+			if waitErr := waitError(t, err); waitErr.Failure != tt.wantFailure {
+				t.Errorf("error failure = %q, want %q", waitErr.Failure, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestWaitInterrupted(t *testing.T) {
+	server := startFakeServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	check := Check{Name: "tables", Run: func(ctx context.Context, conn *pgx.Conn) error {
+		cancel()
+		return NotReady("tables missing")
+	}}
+	res, err := Wait(ctx, testConfig(t, server, check))
+	if res.Failure != FailureInterrupted {
+		t.Errorf("Wait() failure = %q, want %q", res.Failure, FailureInterrupted)
+	}
+	if waitErr := waitError(t, err); waitErr.Check != "tables" {
+		t.Errorf("error check = %q, want tables", waitErr.Check)
+	}
+}
+
+func TestWaitInvert(t *testing.T) {
+	server := startFakeServer(t)
+	tests := []struct {
+		name         string
+		failures     []error // Of the connect
+		checkErrs    []error // Nil for no check
+		disabled     bool    // The check is disabled
+		wantReady    bool
+		wantAttempts int
+		wantRuns     int32
+	}{
+		{name: "unreachable", failures: []error{errRefused}, wantReady: true, wantAttempts: 1},
+		{name: "unreachable, check disabled", failures: []error{errRefused}, checkErrs: []error{}, disabled: true, wantReady: true, wantAttempts: 1},
+		{name: "still reachable"},
+		// A disabled check doesn't run, so it can't stand in for the database being gone
+		{name: "still reachable, check disabled", checkErrs: []error{}, disabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs atomic.Int32
+			cfg := testConfig(t, server)
+			if tt.checkErrs != nil {
+				cfg.Checks = []Check{countingCheck("tables", &runs, tt.checkErrs...)}
+				cfg.Checks[0].Disabled = tt.disabled
+			}
+			cfg.Invert = true
+			cfg.Timeout = 100 * time.Millisecond
+			connect := &stubConnect{failures: tt.failures}
+			res, err := wait(context.Background(), cfg, connect.connect)
+			if res.Ready != tt.wantReady || (err == nil) != tt.wantReady {
+				t.Fatalf("wait() = ready %v, error %v; want ready %v", res.Ready, err, tt.wantReady)
+			}
+			if !tt.wantReady {
+				if waitErr := waitError(t, err); waitErr.Failure != FailureCheck {
+					t.Errorf("error failure = %q, want %q", waitErr.Failure, FailureCheck)
+				}
+				if !strings.Contains(err.Error(), "database still accepting connections") || runs.Load() != 0 {
+					t.Errorf("error = %v after %d check runs, want the database still accepting connections", err, runs.Load())
+				}
+				return
+			}
+			if res.Attempts != tt.wantAttempts || runs.Load() != tt.wantRuns {
+				t.Errorf("attempts = %d, check runs = %d; want %d, %d", res.Attempts, runs.Load(), tt.wantAttempts, tt.wantRuns)
+			}
+		})
+	}
+
+	// Checks can't run on a database that's gone, so an enabled one is a mistake
+	cfg := testConfig(t, server, Check{Name: "tables", Run: func(ctx context.Context, conn *pgx.Conn) error { return nil }})
+	cfg.Invert = true
+	if _, err := wait(context.Background(), cfg, (&stubConnect{}).connect); err == nil || !strings.Contains(err.Error(), "Invert") {
+		t.Errorf("wait() with Invert and an enabled check error = %v", err)
+	}
+}
+
+func TestWaitSuccessCount(t *testing.T) {
+	server := startFakeServer(t)
+	var runs atomic.Int32
+	// Passes, fails (breaking the streak), then passes for good
+	check := Check{Name: "flaky", Run: func(ctx context.Context, conn *pgx.Conn) error {
+		if runs.Add(1) == 2 {
+			return NotReady("blip")
+		}
+		return nil
+	}}
+	cfg := testConfig(t, server, check)
+	cfg.SuccessCount = 3
+	res, err := Wait(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if res.Attempts != 5 {
+		t.Errorf("attempts = %d, want 5 (one success, one failure, three successes)", res.Attempts)
+	}
+}
+
+func TestWaitReuseConnection(t *testing.T) {
+	server := startFakeServer(t)
+	tests := []struct {
+		name         string
+		reuse        bool
+		concurrency  int
+		disableFirst bool
+		wantFirst    int32 // Runs of the check before the flaky one
+		wantConnects int32
+	}{
+		{name: "reconnect each attempt", wantFirst: 3, wantConnects: 3},
+		{name: "reuse", reuse: true, wantFirst: 1, wantConnects: 1},
+		{name: "reuse, concurrent", reuse: true, concurrency: 3, wantFirst: 1, wantConnects: 3},
+		{name: "reuse, disabled check first", reuse: true, disableFirst: true, wantFirst: 1, wantConnects: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var disabledRuns, firstRuns, flakyRuns, lastRuns atomic.Int32
+			checks := []Check{
+				countingCheck("first", &firstRuns),
+				countingCheck("flaky", &flakyRuns, NotReady("not yet"), NotReady("still not")),
+				countingCheck("last", &lastRuns),
+			}
+			if tt.disableFirst {
+				disabled := countingCheck("disabled", &disabledRuns)
+				disabled.Disabled = true
+				checks = append([]Check{disabled}, checks...)
+			}
+			cfg := testConfig(t, server, checks...)
+			cfg.ReuseConnection = tt.reuse
+			cfg.CheckConcurrency = tt.concurrency
+			connect := &stubConnect{}
+			res, err := wait(context.Background(), cfg, connect.connect)
+			if err != nil {
+				t.Fatalf("wait() error = %v", err)
+			}
+			if res.Attempts != 3 || flakyRuns.Load() != 3 {
+				t.Errorf("attempts = %d, flaky runs = %d; want 3 and 3", res.Attempts, flakyRuns.Load())
+			}
+			if firstRuns.Load() != tt.wantFirst {
+				t.Errorf("first check ran %d times, want %d", firstRuns.Load(), tt.wantFirst)
+			}
+			if disabledRuns.Load() != 0 {
+				t.Errorf("disabled check ran %d times", disabledRuns.Load())
+			}
+			if connect.calls.Load() != tt.wantConnects {
+				t.Errorf("connected %d times, want %d", connect.calls.Load(), tt.wantConnects)
+			}
+			// The checks that passed in earlier attempts are still reported as passed
+			for _, r := range res.Checks {
+				if want := StatusPassed; r.Name != "disabled" && r.Status != want {
+					t.Errorf("check %s = %s, want %s", r.Name, r.Status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitOwnBackendPIDs(t *testing.T) {
+	server := startFakeServer(t)
+	var mu sync.Mutex
+	seen := map[uint32]bool{}
+	record := func(ctx context.Context, conn *pgx.Conn) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, pid := range OwnBackendPIDs(ctx) {
+			seen[pid] = true
+		}
+		if !slices.Contains(OwnBackendPIDs(ctx), conn.PgConn().PID()) {
+			t.Errorf("OwnBackendPIDs() = %v, missing the check's own connection %d", OwnBackendPIDs(ctx), conn.PgConn().PID())
+		}
+		return nil
+	}
+	cfg := testConfig(t, server,
+		Check{Name: "a", Run: record}, Check{Name: "b", Run: record}, Check{Name: "admin", Privileged: true, Run: record})
+	cfg.AdminConnConfig = server.connConfig(t)
+	cfg.CheckConcurrency = 2
+	if _, err := Wait(context.Background(), cfg); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	// Two app connections and the admin one
+	if len(seen) != 3 {
+		t.Errorf("checks saw PIDs %v, want 3", seen)
+	}
+	if OwnBackendPIDs(context.Background()) != nil {
+		t.Error("OwnBackendPIDs() outside of Wait isn't nil")
+	}
+}
+
+func TestWaitNoConnConfig(t *testing.T) {
+	if _, err := Wait(context.Background(), Config{}); err == nil {
+		t.Error("Wait() without a ConnConfig succeeded")
+	}
+}
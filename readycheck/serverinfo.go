@@ -0,0 +1,26 @@
+package readycheck
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ServerInfo describes the server a successful attempt connected to.
+type ServerInfo struct {
+	Version    string `json:"version"`     // version(), e.g. "PostgreSQL 16.2 on x86_64-pc-linux-gnu, ..."
+	VersionNum int    `json:"version_num"` // server_version_num, e.g. 160002
+	InRecovery bool   `json:"in_recovery"` // A replica (or a primary still replaying WAL)
+}
+
+// collectServerInfo reads what the server says about itself in a single query.
+func collectServerInfo(ctx context.Context, conn *pgx.Conn) (ServerInfo, error) {
+	var info ServerInfo
+	err := conn.QueryRow(ctx, `SELECT version(), current_setting('server_version_num')::int, pg_is_in_recovery()`).
+		Scan(&info.Version, &info.VersionNum, &info.InRecovery)
+	if err != nil {
+		return ServerInfo{}, fmt.Errorf("error querying server info: %w", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,119 @@
+package readycheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SplitQualifiedName splits 'schema.table' into its parts.
+// Assumes defaultSchema (normally 'public') if not specified.
+// Either part may be double-quoted as in SQL, e.g. to hold a dot ("my.schema".table); the quotes
+// are removed. Case is kept as given: folding unquoted names to lower case is up to the caller.
+func SplitQualifiedName(name, defaultSchema string) (schema, object string) {
+	quoted := false
+	for i, r := range name {
+		switch {
+		case r == '"':
+			quoted = !quoted // A doubled quote inside quotes toggles twice
+		case r == '.' && !quoted:
+			return UnquoteIdentifier(name[:i]), UnquoteIdentifier(name[i+1:])
+		}
+	}
+	return defaultSchema, UnquoteIdentifier(name)
+}
+
+// UnquoteIdentifier removes the double quotes around an identifier like "Users", turning each
+// doubled quote inside into one. Anything else is returned as is.
+func UnquoteIdentifier(name string) string {
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return name
+}
+
+// TablesCheck returns a check that waits for all the tables to exist, looking up the ones given
+// without a schema in defaultSchema.
+func TablesCheck(tables []string, defaultSchema string) Check {
+	return Check{Name: "tables", Objects: tables, Run: func(ctx context.Context, conn *pgx.Conn) error {
+		missing, err := MissingTables(ctx, conn, tables, defaultSchema, "")
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return ObjectsNotReady("required tables missing", MissingObjects(missing, ""))
+		}
+		return nil
+	}}
+}
+
+// MissingTables checks if all specified tables exist in the database.
+// The built-in check looks all tables up in a single query. A custom query is run once per table
+// with the schema and table name as $1 and $2; if it returns any row the table exists.
+// Returns a list of missing tables, in input order, and an error if the query failed.
+func MissingTables(ctx context.Context, conn *pgx.Conn, tables []string, defaultSchema, query string) ([]string, error) {
+	missing := []string{}
+	if len(tables) == 0 {
+		return missing, nil // Nothing to check
+	}
+	if query == "" {
+		return missingTablesBatched(ctx, conn, tables, defaultSchema)
+	}
+
+	for _, table := range tables {
+		schemaName, tableName := SplitQualifiedName(table, defaultSchema)
+		rows, err := conn.Query(ctx, query, schemaName, tableName)
+		if err != nil {
+			return nil, fmt.Errorf("error querying for table '%s': %w", table, err)
+		}
+		exists := rows.Next() // Any row at all means the table exists
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			// An actual error occurred during the query
+			return nil, fmt.Errorf("error querying for table '%s': %w", table, err)
+		}
+		if !exists {
+			missing = append(missing, table)
+		}
+	}
+
+	return missing, nil
+}
+
+// missingTablesBatched is the built-in table existence check, fetching all the tables that
+// exist in one round trip instead of one per table. Returns the missing ones, in input order.
+func missingTablesBatched(ctx context.Context, conn *pgx.Conn, tables []string, defaultSchema string) ([]string, error) {
+	schemas := make([]string, len(tables))
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		schemas[i], names[i] = SplitQualifiedName(table, defaultSchema)
+	}
+
+	rows, err := conn.Query(ctx, `SELECT t.table_schema::text, t.table_name::text
+		FROM information_schema.tables t
+		JOIN unnest($1::text[], $2::text[]) AS wanted(schema_name, table_name)
+		  ON t.table_schema = wanted.schema_name AND t.table_name = wanted.table_name`, schemas, names)
+	if err != nil {
+		return nil, fmt.Errorf("error querying for tables: %w", err)
+	}
+	found := map[[2]string]bool{}
+	var schemaName, tableName string
+	_, err = pgx.ForEachRow(rows, []any{&schemaName, &tableName}, func() error {
+		found[[2]string{schemaName, tableName}] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying for tables: %w", err)
+	}
+
+	missing := []string{}
+	for i, table := range tables {
+		if !found[[2]string{schemas[i], names[i]}] {
+			missing = append(missing, table)
+		}
+	}
+	return missing, nil
+}
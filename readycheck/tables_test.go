@@ -0,0 +1,46 @@
+package readycheck
+
+import "testing"
+
+func TestSplitQualifiedName(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantSchema string
+		wantObject string
+	}{
+		{name: "a.b", wantSchema: "a", wantObject: "b"},
+		{name: "users", wantSchema: "public", wantObject: "users"},
+		{name: `"a.b".c`, wantSchema: "a.b", wantObject: "c"},
+		{name: `a."b.c"`, wantSchema: "a", wantObject: "b.c"},
+		{name: `"a""b".c`, wantSchema: `a"b`, wantObject: "c"},
+		{name: `"My Table"`, wantSchema: "public", wantObject: "My Table"},
+		{name: "Billing.Invoices", wantSchema: "Billing", wantObject: "Invoices"}, // Folding is the caller's
+		{name: "a.b.c", wantSchema: "a", wantObject: "b.c"},
+		// Unterminated: the dot is inside the quotes, and the quote is kept for lack of a closing one
+		{name: `"a.b`, wantSchema: "public", wantObject: `"a.b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, object := SplitQualifiedName(tt.name, "public")
+			if schema != tt.wantSchema || object != tt.wantObject {
+				t.Errorf("SplitQualifiedName(%q) = %q, %q; want %q, %q", tt.name, schema, object, tt.wantSchema, tt.wantObject)
+			}
+		})
+	}
+}
+
+func TestUnquoteIdentifier(t *testing.T) {
+	tests := map[string]string{
+		`"Users"`:   "Users",
+		`"a""b"`:    `a"b`,
+		`users`:     "users",
+		`"`:         `"`,
+		`"unclosed`: `"unclosed`,
+		`""`:        "",
+	}
+	for in, want := range tests {
+		if got := UnquoteIdentifier(in); got != want {
+			t.Errorf("UnquoteIdentifier(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
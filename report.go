@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/alchen99/pg_ready_check/readycheck"
+)
+
+// runResult is the overall outcome reported on exit.
+type runResult struct {
+	Ready      bool                     `json:"ready"`
+	ExitCode   int                      `json:"exit_code"`
+	Duration   time.Duration            `json:"-"`
+	Attempts   int                      `json:"attempts"`              // Connection attempts made, including the last
+	Slow       bool                     `json:"slow"`                  // Ready, but only after longer than -warn-after
+	Server     string                   `json:"server,omitempty"`      // Address of the server last connected to
+	ServerInfo *readycheck.ServerInfo   `json:"server_info,omitempty"` // What the server said about itself, when ready
+	Error      string                   `json:"error,omitempty"`       // Last error, when not ready
+	ErrorKind  readycheck.Failure       `json:"error_kind,omitempty"`  // What the error is about: connection, auth, check, internal or interrupted
+	Checks     []readycheck.CheckResult `json:"checks"`                // The connection first, then each configured check
+}
+
+// jsonResult is the result as printed with -output=json, adding the duration in milliseconds.
+type jsonResult struct {
+	runResult
+	DurationMs int64 `json:"duration_ms"`
+}
+
+func newJSONResult(result runResult) jsonResult {
+	return jsonResult{result, result.Duration.Milliseconds()}
+}
+
+// printOutputs writes the result to w through the -template or as -output asks, or nothing at all
+// for a ready run with -output-on-failure-only.
+func printOutputs(w io.Writer, o *options, result runResult) error {
+	if o.failureOnly && result.Ready {
+		return nil
+	}
+	if o.resultTmpl != nil {
+		return printResultTemplate(w, o.resultTmpl, result)
+	}
+	if o.outputFormat != "" {
+		return printResult(w, o.outputFormat, result)
+	}
+	return nil
+}
+
+// printResult writes the result to w as "text", "json" or "csv".
+func printResult(w io.Writer, format string, result runResult) error {
+	if format == "csv" {
+		return writeResultCSV(w, result)
+	}
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(newJSONResult(result))
+	}
+
+	status := "ready"
+	if !result.Ready {
+		status = "not ready"
+	}
+	slow := ""
+	if result.Slow {
+		slow = ", slow"
+	}
+	fmt.Fprintf(w, "%s after %s (exit code %d%s)\n", status, result.Duration.Round(time.Millisecond), result.ExitCode, slow)
+	if result.Server != "" {
+		fmt.Fprintf(w, "server: %s\n", result.Server)
+	}
+	if info := result.ServerInfo; info != nil {
+		role := "primary"
+		if info.InRecovery {
+			role = "in recovery"
+		}
+		fmt.Fprintf(w, "server version: %s (%s)\n", info.Version, role)
+	}
+	if result.Error != "" {
+		fmt.Fprintf(w, "error: %s\n", result.Error)
+	}
+	for _, c := range result.Checks {
+		line := fmt.Sprintf("  %-8s %s", c.Status, c.Name)
+		if c.Message != "" {
+			line += ": " + c.Message
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateResult is what a -template is evaluated against: the fields of -output=json, by their
+// Go names (.Ready, .DurationMs, .Checks, ...), and MissingTables.
+type templateResult struct {
+	jsonResult
+}
+
+// MissingTables lists the tables from -tables not found in the last check.
+func (r templateResult) MissingTables() []string {
+	return missingTables(r.Checks)
+}
+
+// parseResultTemplate parses a -template. Since most mistakes, like a misspelled field, only show
+// when a template runs, it is also run once on an empty result, so they're caught before connecting.
+func parseResultTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("result").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := runResult{ServerInfo: &readycheck.ServerInfo{}} // So .ServerInfo.Version works
+	if err := printResultTemplate(io.Discard, tmpl, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// printResultTemplate writes the result to w through tmpl, ending it with a newline if it doesn't.
+func printResultTemplate(w io.Writer, tmpl *template.Template, result runResult) error {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, templateResult{newJSONResult(result)}); err != nil {
+		return err
+	}
+	if b.Len() == 0 || b.Bytes()[b.Len()-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeResultCSV writes one row per checked object (or per check, for checks that
+// aren't about named objects) with the columns category, name, status and message.
+func writeResultCSV(w io.Writer, result runResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"category", "name", "status", "message"})
+	for _, c := range result.Checks {
+		if len(c.Objects) == 0 {
+			cw.Write([]string{c.Name, "", string(c.Status), c.Message})
+			continue
+		}
+		for _, o := range c.Objects {
+			cw.Write([]string{c.Name, o.Name, string(o.Status), o.Message})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// --- JUnit XML ---
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnitReport writes the check results as a JUnit XML test suite, one test case per check.
+func writeJUnitReport(path string, results []readycheck.CheckResult, elapsed time.Duration) error {
+	suite := junitTestSuite{
+		Name:      "pg_ready_check",
+		Tests:     len(results),
+		Time:      junitSeconds(elapsed),
+		Timestamp: time.Now().Add(-elapsed).UTC().Format(time.RFC3339),
+	}
+	for _, r := range results {
+		tc := junitTestCase{Name: r.Name, ClassName: "pg_ready_check", Time: junitSeconds(r.Duration)}
+		switch r.Status {
+		case readycheck.StatusFailed:
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: r.Message}
+		case readycheck.StatusSkipped:
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: r.Message}
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JUnit report: %w", err)
+	}
+	return writeFileAtomic(path, append([]byte(xml.Header), append(data, '\n')...))
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// --- Prometheus textfile metrics ---
+
+// writeMetricsFile writes the result in the Prometheus text exposition format, for
+// node_exporter's textfile collector (which needs the file replaced atomically).
+func writeMetricsFile(path string, result runResult) error {
+	success := 0
+	if result.Ready {
+		success = 1
+	}
+	var b strings.Builder
+	writeMetric(&b, "pg_ready_check_success", "gauge", "Whether the database became ready (1) or not (0).", fmt.Sprint(success))
+	writeMetric(&b, "pg_ready_check_duration_seconds", "gauge", "How long the run took until ready or given up.", fmt.Sprintf("%.3f", result.Duration.Seconds()))
+	writeMetric(&b, "pg_ready_check_attempts_total", "counter", "Connection attempts made during the run.", fmt.Sprint(result.Attempts))
+	writeMetric(&b, "pg_ready_check_missing_tables", "gauge", "Tables from -tables not found in the last check.", fmt.Sprint(len(missingTables(result.Checks))))
+	return writeFileAtomic(path, []byte(b.String()))
+}
+
+func writeMetric(b *strings.Builder, name, metricType, help, value string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, metricType, name, value)
+}
+
+// missingTables lists the tables the tables checks last reported as failed, including those in
+// other databases.
+func missingTables(results []readycheck.CheckResult) []string {
+	missing := []string{}
+	for _, r := range results {
+		if r.Name == "tables" || strings.HasPrefix(r.Name, "tables in ") {
+			missing = append(missing, r.FailedObjects()...)
+		}
+	}
+	return missing
+}
+
+// --- Webhook ---
+
+// notifyWebhook POSTs the result to webhookURL as JSON, the same as -output=json prints it.
+// Any 2xx response counts as delivered. Errors leave out the URL, which may hold a token.
+func notifyWebhook(ctx context.Context, webhookURL string, result runResult) error {
+	body, err := json.Marshal(newJSONResult(result))
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pg_ready_check")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Lets the connection be reused, not that we will
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// --- Helpers ---
+
+// writeFileAtomic writes data to a temp file next to path and renames it into place,
+// so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alchen99/pg_ready_check/readycheck"
+)
+
+// sampleResult is a run that gave up on missing tables, with a check skipped.
+func sampleResult() runResult {
+	return runResult{
+		Ready:     false,
+		ExitCode:  ExitCodeCheckFailed,
+		Duration:  2500 * time.Millisecond,
+		Attempts:  3,
+		Server:    "db:5432",
+		Error:     "required tables missing: orders, events (empty)",
+		ErrorKind: readycheck.FailureCheck,
+		Checks: []readycheck.CheckResult{
+			{Name: "connection", Status: readycheck.StatusPassed, Duration: 20 * time.Millisecond},
+			{Name: "tables", Status: readycheck.StatusFailed, Message: "required tables missing: orders, events (empty)", Duration: 5 * time.Millisecond,
+				Objects: []readycheck.ObjectResult{
+					{Name: "users", Status: readycheck.StatusPassed},
+					{Name: "orders", Status: readycheck.StatusFailed, Message: "required tables missing"},
+					{Name: "events", Status: readycheck.StatusFailed, Message: "empty"},
+				}},
+			{Name: "tables in db2", Status: readycheck.StatusFailed, Message: "required tables missing: audit",
+				Objects: []readycheck.ObjectResult{{Name: "audit", Status: readycheck.StatusFailed, Message: "required tables missing"}}},
+			{Name: "recovery state", Status: readycheck.StatusSkipped, Message: "not run: an earlier check failed"},
+		},
+	}
+}
+
+// parseMetrics reads the samples of a Prometheus text file back, by metric name, checking each
+// has its HELP and TYPE lines.
+func parseMetrics(t *testing.T, text string) map[string]string {
+	t.Helper()
+	samples := map[string]string{}
+	described := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if rest, ok := strings.CutPrefix(line, "# "); ok {
+			kind, name, _ := strings.Cut(rest, " ")
+			name, _, _ = strings.Cut(name, " ")
+			if kind != "HELP" && kind != "TYPE" {
+				t.Errorf("unexpected comment %q", line)
+			}
+			described[name]++
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		if !ok || strings.Contains(value, " ") {
+			t.Errorf("malformed sample %q", line)
+			continue
+		}
+		samples[name] = value
+	}
+	for name := range samples {
+		if described[name] != 2 {
+			t.Errorf("%s has %d HELP and TYPE lines, want 2", name, described[name])
+		}
+	}
+	return samples
+}
+
+func TestPrintResult(t *testing.T) {
+	result := sampleResult()
+	result.Slow = true
+	result.ServerInfo = &readycheck.ServerInfo{Version: "16.4", InRecovery: true}
+	var sb strings.Builder
+	if err := printResult(&sb, "text", result); err != nil {
+		t.Fatal(err)
+	}
+	want := `not ready after 2.5s (exit code 2, slow)
+server: db:5432
+server version: 16.4 (in recovery)
+error: required tables missing: orders, events (empty)
+  passed   connection
+  failed   tables: required tables missing: orders, events (empty)
+  failed   tables in db2: required tables missing: audit
+  skipped  recovery state: not run: an earlier check failed
+`
+	if sb.String() != want {
+		t.Errorf("text result =\n%s\nwant\n%s", sb.String(), want)
+	}
+
+	sb.Reset()
+	if err := printResult(&sb, "text", runResult{Ready: true, Duration: 1234567 * time.Microsecond}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ready after 1.235s (exit code 0)\n"; sb.String() != want {
+		t.Errorf("text result = %q, want %q", sb.String(), want)
+	}
+
+	sb.Reset()
+	if err := printResult(&sb, "json", result); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(sb.String()), &got); err != nil {
+		t.Fatalf("json result isn't JSON: %v\n%s", err, sb.String())
+	}
+	if got["ready"] != false || got["exit_code"] != 2.0 || got["duration_ms"] != 2500.0 || got["error_kind"] != "check" {
+		t.Errorf("json result = %v", got)
+	}
+	if checks, _ := got["checks"].([]any); len(checks) != len(result.Checks) {
+		t.Errorf("json result has checks %v, want %d", got["checks"], len(result.Checks))
+	}
+}
+
+func TestWriteResultCSV(t *testing.T) {
+	result := sampleResult()
+	result.Checks = append(result.Checks, readycheck.CheckResult{Name: "check query", Status: readycheck.StatusFailed,
+		Message: "got \"no\", want \"yes\",\nafter 3 rows"})
+	var sb strings.Builder
+	if err := printResult(&sb, "csv", result); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(sb.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("csv result doesn't parse: %v\n%s", err, sb.String())
+	}
+	want := [][]string{
+		{"category", "name", "status", "message"},
+		{"connection", "", "passed", ""},
+		{"tables", "users", "passed", ""},
+		{"tables", "orders", "failed", "required tables missing"},
+		{"tables", "events", "failed", "empty"},
+		{"tables in db2", "audit", "failed", "required tables missing"},
+		{"recovery state", "", "skipped", "not run: an earlier check failed"},
+		{"check query", "", "failed", "got \"no\", want \"yes\",\nafter 3 rows"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("csv rows = %q, want %q", rows, want)
+	}
+
+	// Only the header when there's nothing checked
+	sb.Reset()
+	if err := writeResultCSV(&sb, runResult{}); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != "category,name,status,message\n" {
+		t.Errorf("csv result without checks = %q", sb.String())
+	}
+}
+
+func TestPrintOutputs(t *testing.T) {
+	failed, ready := sampleResult(), runResult{Ready: true, Duration: time.Second, Attempts: 1}
+	tests := []struct {
+		name       string
+		args       []string
+		result     runResult
+		wantPrefix string // Empty for no output at all
+	}{
+		{name: "no -output", result: failed},
+		{name: "text", args: []string{"-output", "text"}, result: ready, wantPrefix: "ready after 1s"},
+		{name: "failure only, ready", args: []string{"-output-on-failure-only"}, result: ready},
+		{name: "failure only, failed", args: []string{"-output-on-failure-only"}, result: failed, wantPrefix: "not ready after 2.5s"},
+		{name: "failure only json, ready", args: []string{"-output-on-failure-only", "-output", "json"}, result: ready},
+		{name: "failure only json, failed", args: []string{"-output-on-failure-only", "-output", "json"}, result: failed, wantPrefix: "{"},
+		{name: "failure only template, ready", args: []string{"-output-on-failure-only", "-template", "{{.Ready}}"}, result: ready},
+		{name: "failure only template, failed", args: []string{"-output-on-failure-only", "-template", "{{.Ready}}"}, result: failed, wantPrefix: "false\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := parseTestOptions(t, tt.args...)
+			if err != nil {
+				t.Fatalf("parseOptions() error = %v", err)
+			}
+			var sb strings.Builder
+			if err := printOutputs(&sb, o, tt.result); err != nil {
+				t.Fatalf("printOutputs() error = %v", err)
+			}
+			if tt.wantPrefix == "" && sb.Len() != 0 || !strings.HasPrefix(sb.String(), tt.wantPrefix) {
+				t.Errorf("output = %q, want it to start with %q", sb.String(), tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pg_ready_check.prom")
+	if err := os.WriteFile(path, []byte("stale\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		result runResult
+		want   map[string]string
+	}{
+		{sampleResult(), map[string]string{
+			"pg_ready_check_success":          "0",
+			"pg_ready_check_duration_seconds": "2.500",
+			"pg_ready_check_attempts_total":   "3",
+			"pg_ready_check_missing_tables":   "3",
+		}},
+		{runResult{Ready: true, Duration: 42 * time.Millisecond, Attempts: 1}, map[string]string{
+			"pg_ready_check_success":          "1",
+			"pg_ready_check_duration_seconds": "0.042",
+			"pg_ready_check_attempts_total":   "1",
+			"pg_ready_check_missing_tables":   "0",
+		}},
+	} {
+		if err := writeMetricsFile(path, tt.result); err != nil {
+			t.Fatalf("writeMetricsFile() error = %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := parseMetrics(t, string(data))
+		if len(got) != len(tt.want) {
+			t.Errorf("metrics = %v, want %v", got, tt.want)
+		}
+		for name, want := range tt.want {
+			if got[name] != want {
+				t.Errorf("%s = %q, want %q", name, got[name], want)
+			}
+		}
+	}
+
+	// Replaced by a rename, with no temp file left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the metrics file", len(entries))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("metrics file mode = %v, want 0644 for the collector", info.Mode())
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	result := sampleResult()
+	if err := writeJUnitReport(path, result.Checks, result.Duration); err != nil {
+		t.Fatalf("writeJUnitReport() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Errorf("report doesn't start with the XML header:\n%s", data)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		t.Fatalf("report isn't XML: %v\n%s", err, data)
+	}
+	if suite.Name != "pg_ready_check" || suite.Tests != 4 || suite.Failures != 2 || suite.Skipped != 1 || suite.Time != "2.500" {
+		t.Errorf("suite = %s: %d tests, %d failures, %d skipped in %s; want 4, 2, 1 in 2.500",
+			suite.Name, suite.Tests, suite.Failures, suite.Skipped, suite.Time)
+	}
+	if _, err := time.Parse(time.RFC3339, suite.Timestamp); err != nil {
+		t.Errorf("timestamp %q: %v", suite.Timestamp, err)
+	}
+	if len(suite.TestCases) != len(result.Checks) {
+		t.Fatalf("%d test cases, want one per check", len(suite.TestCases))
+	}
+	for i, tc := range suite.TestCases {
+		check := result.Checks[i]
+		if tc.Name != check.Name || tc.ClassName != "pg_ready_check" || tc.Time != junitSeconds(check.Duration) {
+			t.Errorf("test case %+v, want %s taking %s", tc, check.Name, junitSeconds(check.Duration))
+		}
+		failed, skipped := check.Status == readycheck.StatusFailed, check.Status == readycheck.StatusSkipped
+		if (tc.Failure != nil) != failed || (tc.Skipped != nil) != skipped {
+			t.Errorf("%s: failure %v, skipped %v; want the check's %s status", tc.Name, tc.Failure, tc.Skipped, check.Status)
+		}
+		if tc.Failure != nil && tc.Failure.Message != check.Message || tc.Skipped != nil && tc.Skipped.Message != check.Message {
+			t.Errorf("%s: message %+v %+v, want %q", tc.Name, tc.Failure, tc.Skipped, check.Message)
+		}
+	}
+	if tc := suite.TestCases[0]; tc.Time != "0.020" {
+		t.Errorf("connection time = %s, want 0.020", tc.Time)
+	}
+
+	// A run that never connected has no checks, but still an empty suite for the CI to read
+	if err := writeJUnitReport(path, nil, time.Second); err != nil {
+		t.Fatalf("writeJUnitReport() with no checks error = %v", err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suite = junitTestSuite{}
+	if err := xml.Unmarshal(data, &suite); err != nil || suite.Tests != 0 || len(suite.TestCases) != 0 {
+		t.Errorf("report without checks = %+v, %v; want an empty suite", suite, err)
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	var got struct {
+		method, contentType string
+		body                map[string]any
+	}
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.contentType = r.Method, r.Header.Get("Content-Type")
+		got.body = nil
+		if err := json.NewDecoder(r.Body).Decode(&got.body); err != nil {
+			t.Errorf("webhook body isn't JSON: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	if err := notifyWebhook(context.Background(), server.URL+"/hooks/db", sampleResult()); err != nil {
+		t.Fatalf("notifyWebhook() error = %v", err)
+	}
+	if got.method != http.MethodPost || got.contentType != "application/json" {
+		t.Errorf("request = %s with %q, want a JSON POST", got.method, got.contentType)
+	}
+	// The same shape as -output=json
+	var want map[string]any
+	data, _ := json.Marshal(newJSONResult(sampleResult()))
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.body, want) {
+		t.Errorf("payload = %v, want %v", got.body, want)
+	}
+	for _, key := range []string{"ready", "exit_code", "duration_ms", "attempts", "error", "error_kind", "checks"} {
+		if _, ok := got.body[key]; !ok {
+			t.Errorf("payload has no %q", key)
+		}
+	}
+	if got.body["duration_ms"] != 2500.0 || got.body["ready"] != false {
+		t.Errorf("duration_ms, ready = %v, %v", got.body["duration_ms"], got.body["ready"])
+	}
+
+	status = http.StatusInternalServerError
+	if err := notifyWebhook(context.Background(), server.URL, sampleResult()); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("notifyWebhook() with a 500 response error = %v, want one with the status", err)
+	}
+}
+
+func TestNotifyWebhookErrors(t *testing.T) {
+	blocked := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer server.Close()
+	defer close(blocked)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := notifyWebhook(ctx, server.URL+"/hooks?token=secret", sampleResult())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("notifyWebhook() to a hanging server error = %v, want the deadline", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q gives away the URL's token", err)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	if err := notifyWebhook(context.Background(), closed.URL+"/hooks?token=secret", sampleResult()); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("notifyWebhook() to a closed server error = %v, want one without the URL", err)
+	}
+}
+
+func TestPrintResultTemplate(t *testing.T) {
+	result := sampleResult()
+	result.ServerInfo = &readycheck.ServerInfo{Version: "16.4"}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"ready={{.Ready}} dur={{.DurationMs}} missing={{len .MissingTables}}", "ready=false dur=2500 missing=3\n"},
+		{`{{range .MissingTables}}{{.}} {{end}}`, "orders events audit \n"},
+		{"{{.ExitCode}} {{.ErrorKind}} {{.Attempts}}\n", "2 check 3\n"}, // No second newline
+		{`{{.ServerInfo.Version}}{{range .Checks}} {{.Name}}={{.Status}}{{end}}`, "16.4 connection=passed tables=failed tables in db2=failed recovery state=skipped\n"},
+		{"", "\n"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseResultTemplate(tt.template)
+		if err != nil {
+			t.Errorf("parseResultTemplate(%q) error = %v", tt.template, err)
+			continue
+		}
+		var sb strings.Builder
+		if err := printResultTemplate(&sb, tmpl, result); err != nil {
+			t.Errorf("printResultTemplate(%q) error = %v", tt.template, err)
+		}
+		if sb.String() != tt.want {
+			t.Errorf("printResultTemplate(%q) = %q, want %q", tt.template, sb.String(), tt.want)
+		}
+	}
+}
+
+func TestParseResultTemplateErrors(t *testing.T) {
+	// Caught at startup, before connecting, including the mistakes that only show when it runs
+	for _, text := range []string{
+		"{{.Ready",
+		"{{.Readyy}}",
+		"{{.missingTables}}",
+		"{{len .Ready}}",
+		"{{.ServerInfo.Versoin}}",
+	} {
+		if _, err := parseResultTemplate(text); err == nil {
+			t.Errorf("parseResultTemplate(%q) succeeded, want an error", text)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/alchen99/pg_ready_check/readycheck"
+)
+
+// settingUnitKind groups Postgres setting units that can be converted into each other.
+type settingUnitKind int
+
+const (
+	unitNone settingUnitKind = iota
+	unitMemory
+	unitTime
+)
+
+// settingUnits are the units Postgres accepts in memory and time settings (case-sensitive,
+// as in postgresql.conf), as multipliers of a base unit: bytes for memory, milliseconds for time.
+var settingUnits = map[string]struct {
+	kind       settingUnitKind
+	multiplier float64
+}{
+	"B":   {unitMemory, 1},
+	"kB":  {unitMemory, 1 << 10},
+	"MB":  {unitMemory, 1 << 20},
+	"GB":  {unitMemory, 1 << 30},
+	"TB":  {unitMemory, 1 << 40},
+	"us":  {unitTime, 0.001},
+	"ms":  {unitTime, 1},
+	"s":   {unitTime, 1000},
+	"min": {unitTime, 60 * 1000},
+	"h":   {unitTime, 60 * 60 * 1000},
+	"d":   {unitTime, 24 * 60 * 60 * 1000},
+}
+
+// settingComparisons are the operators accepted by -settings-min, longest first so ">=" wins over ">".
+var settingComparisons = []string{">=", "<=", "!=", ">", "<", "="}
+
+// settingRequirement is one numeric condition on a server setting, e.g. "work_mem>=4MB".
+type settingRequirement struct {
+	name  string
+	op    string
+	value float64
+	unit  string // As written by the user; empty means the setting's own unit
+	raw   string // The value as written, for messages
+}
+
+// parseSettingRequirements parses the comma-separated -settings-min value.
+func parseSettingRequirements(value string) ([]settingRequirement, error) {
+	var reqs []settingRequirement
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		req, err := parseSettingRequirement(entry)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+func parseSettingRequirement(entry string) (settingRequirement, error) {
+	for _, op := range settingComparisons {
+		name, raw, found := strings.Cut(entry, op)
+		if !found {
+			continue
+		}
+		name, raw = strings.TrimSpace(name), strings.TrimSpace(raw)
+		if name == "" {
+			return settingRequirement{}, fmt.Errorf("missing setting name in '%s'", entry)
+		}
+		if strings.ContainsAny(name, "<>=!") {
+			// Like "work_mem=>4MB", which would otherwise ask for a setting named "work_mem="
+			return settingRequirement{}, fmt.Errorf("invalid comparison in '%s' (use one of %s)", entry, strings.Join(settingComparisons, " "))
+		}
+		number, unit, err := splitSettingValue(raw)
+		if err != nil {
+			return settingRequirement{}, fmt.Errorf("invalid value in '%s': %w", entry, err)
+		}
+		return settingRequirement{name: name, op: op, value: number, unit: unit, raw: raw}, nil
+	}
+	return settingRequirement{}, fmt.Errorf("'%s' has no comparison (use one of %s)", entry, strings.Join(settingComparisons, " "))
+}
+
+// splitSettingValue splits a value like "4MB", "1.5s" or "10" into its number and unit,
+// validating the unit against the ones Postgres understands.
+func splitSettingValue(value string) (float64, string, error) {
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.-+", r)
+	})
+	if end == -1 {
+		end = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("'%s' is not a number", value)
+	}
+	unit := strings.TrimSpace(value[end:])
+	if _, ok := settingUnits[unit]; unit != "" && !ok {
+		return 0, "", fmt.Errorf("unknown unit '%s' (use B, kB, MB, GB, TB, us, ms, s, min, h or d)", unit)
+	}
+	return number, unit, nil
+}
+
+// settingBaseValue converts value in the pg_settings unit (which may carry a multiplier, like "8kB")
+// into bytes or milliseconds. A setting without a unit is returned as is.
+func settingBaseValue(value float64, pgUnit string) (float64, settingUnitKind, error) {
+	if pgUnit == "" {
+		return value, unitNone, nil
+	}
+	scale, unit := 1.0, pgUnit
+	if n, u, err := splitSettingValue(pgUnit); err == nil && u != "" {
+		scale, unit = n, u
+	}
+	u, ok := settingUnits[unit]
+	if !ok {
+		return 0, unitNone, fmt.Errorf("unrecognized setting unit '%s'", pgUnit)
+	}
+	return value * scale * u.multiplier, u.kind, nil
+}
+
+// compareSetting reports whether actual op required holds.
+func compareSetting(actual float64, op string, required float64) bool {
+	switch op {
+	case ">=":
+		return actual >= required
+	case "<=":
+		return actual <= required
+	case ">":
+		return actual > required
+	case "<":
+		return actual < required
+	case "=":
+		return actual == required
+	case "!=":
+		return actual != required
+	}
+	return false
+}
+
+// settingNames returns the setting names of the requirements, in order.
+func settingNames(reqs []settingRequirement) []string {
+	names := make([]string, len(reqs))
+	for i, req := range reqs {
+		names[i] = req.name
+	}
+	return names
+}
+
+// checkSettingRequirements reads each setting from pg_settings and compares it numerically,
+// converting units so e.g. "work_mem>=4MB" holds for a setting of 4096 kB. A value without a unit
+// is taken to be in the setting's own unit, as Postgres does. Returns each unmet requirement
+// with the actual value; mismatched or unknown settings are reported as fatal.
+func checkSettingRequirements(ctx context.Context, conn *pgx.Conn, reqs []settingRequirement) ([]readycheck.ObjectProblem, error) {
+	query := `SELECT setting, coalesce(unit, ''), vartype, current_setting(name) FROM pg_settings WHERE name = $1`
+
+	unmet := []readycheck.ObjectProblem{}
+	for _, req := range reqs {
+		var setting, pgUnit, varType, display string
+		err := conn.QueryRow(ctx, query, req.name).Scan(&setting, &pgUnit, &varType, &display)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, readycheck.Misconfigured("unknown setting '%s'", req.name)
+			}
+			return nil, fmt.Errorf("error querying setting '%s': %w", req.name, err)
+		}
+		if varType != "integer" && varType != "real" {
+			return nil, readycheck.Misconfigured("setting '%s' is %s, not numeric", req.name, varType)
+		}
+
+		number, err := strconv.ParseFloat(setting, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected value '%s' for setting '%s': %w", setting, req.name, err)
+		}
+		met, err := settingMeets(req, number, pgUnit)
+		if err != nil {
+			return nil, err
+		}
+		if !met {
+			unmet = append(unmet, readycheck.ObjectProblem{Name: req.name, Detail: fmt.Sprintf("is %s, need %s%s", display, req.op, req.raw)})
+		}
+	}
+	return unmet, nil
+}
+
+// settingMeets reports whether a setting of value in pgUnit, as pg_settings has them, meets req.
+// Both sides are converted to bytes or milliseconds first; a unit of the other kind is reported
+// as fatal.
+func settingMeets(req settingRequirement, value float64, pgUnit string) (bool, error) {
+	actual, actualKind, err := settingBaseValue(value, pgUnit)
+	if err != nil {
+		return false, fmt.Errorf("setting '%s': %w", req.name, err)
+	}
+
+	required, requiredKind := req.value, actualKind
+	if req.unit == "" {
+		// No unit means the setting's own unit, e.g. work_mem>=4096 is in kB
+		required, _, _ = settingBaseValue(req.value, pgUnit)
+	} else {
+		u := settingUnits[req.unit]
+		required, requiredKind = req.value*u.multiplier, u.kind
+	}
+	if requiredKind != actualKind {
+		return false, readycheck.Misconfigured("setting '%s' can't be compared with '%s' (unit mismatch)", req.name, req.raw)
+	}
+	return compareSetting(actual, req.op, required), nil
+}
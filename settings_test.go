@@ -0,0 +1,117 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSettingRequirements(t *testing.T) {
+	reqs, err := parseSettingRequirements("max_prepared_transactions>=10, work_mem >= 4MB,,shared_buffers<=1.5GB,a>1,b<2,c=3,d!=-4,e=+0.5s")
+	if err != nil {
+		t.Fatalf("parseSettingRequirements() error = %v", err)
+	}
+	want := []settingRequirement{
+		{name: "max_prepared_transactions", op: ">=", value: 10, raw: "10"},
+		{name: "work_mem", op: ">=", value: 4, unit: "MB", raw: "4MB"},
+		{name: "shared_buffers", op: "<=", value: 1.5, unit: "GB", raw: "1.5GB"},
+		{name: "a", op: ">", value: 1, raw: "1"},
+		{name: "b", op: "<", value: 2, raw: "2"},
+		{name: "c", op: "=", value: 3, raw: "3"},
+		{name: "d", op: "!=", value: -4, raw: "-4"},
+		{name: "e", op: "=", value: 0.5, unit: "s", raw: "+0.5s"},
+	}
+	if !slices.Equal(reqs, want) {
+		t.Errorf("parseSettingRequirements() = %+v, want %+v", reqs, want)
+	}
+	if got := settingNames(reqs); !slices.Equal(got, []string{"max_prepared_transactions", "work_mem", "shared_buffers", "a", "b", "c", "d", "e"}) {
+		t.Errorf("settingNames() = %q", got)
+	}
+
+	for _, tt := range []struct {
+		value, wantErr string
+	}{
+		{"work_mem", "has no comparison"},
+		{">=4MB", "missing setting name"},
+		{"work_mem>=4mb", "unknown unit 'mb'"},
+		{"work_mem>=4XB", "unknown unit 'XB'"},
+		{"work_mem>=lots", "'lots' is not a number"},
+		{"work_mem=>4MB", "invalid comparison in 'work_mem=>4MB'"},
+		{"work_mem==4MB", "invalid"},
+		{"work_mem<>4MB", "invalid"},
+		{"max_connections>=10,work_mem", "'work_mem' has no comparison"},
+	} {
+		if _, err := parseSettingRequirements(tt.value); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseSettingRequirements(%q) error = %v, want one mentioning %q", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSettingMeets(t *testing.T) {
+	tests := []struct {
+		requirement string
+		value       float64 // pg_settings.setting
+		pgUnit      string  // pg_settings.unit
+		want        bool
+	}{
+		// shared_buffers is counted in 8kB pages: 16384 of them are 128MB
+		{"shared_buffers>=128MB", 16384, "8kB", true},
+		{"shared_buffers>128MB", 16384, "8kB", false},
+		{"shared_buffers=131072kB", 16384, "8kB", true},
+		{"shared_buffers>=1GB", 16384, "8kB", false},
+		{"shared_buffers<1GB", 16384, "8kB", true},
+		{"shared_buffers!=128MB", 16384, "8kB", false},
+		{"shared_buffers<=16384", 16384, "8kB", true}, // In the setting's own unit
+		{"work_mem>=4MB", 4096, "kB", true},
+		{"work_mem>4MB", 4096, "kB", false},
+		{"work_mem>=4096", 4096, "kB", true},
+		{"work_mem=4194304B", 4096, "kB", true},
+		{"max_wal_size>=1GB", 1024, "MB", true},
+		{"max_wal_size<0.5TB", 1024, "MB", true},
+		{"statement_timeout>=30s", 30000, "ms", true},
+		{"statement_timeout<1min", 30000, "ms", true},
+		{"statement_timeout=0.5min", 30000, "ms", true},
+		{"statement_timeout>30000", 30000, "ms", false},
+		{"statement_timeout>=30000000us", 30000, "ms", true},
+		{"checkpoint_timeout=5min", 300, "s", true},
+		{"checkpoint_timeout>=300000ms", 300, "s", true},
+		{"checkpoint_timeout<5min", 300, "s", false},
+		{"log_rotation_age<=1d", 1440, "min", true},
+		{"log_rotation_age>23h", 1440, "min", true},
+		{"max_prepared_transactions>=10", 10, "", true},
+		{"max_prepared_transactions!=10", 10, "", false},
+		{"max_prepared_transactions>9.5", 10, "", true},
+		{"max_prepared_transactions<10", 10, "", false},
+	}
+	for _, tt := range tests {
+		req, err := parseSettingRequirement(tt.requirement)
+		if err != nil {
+			t.Fatalf("parseSettingRequirement(%q) error = %v", tt.requirement, err)
+		}
+		got, err := settingMeets(req, tt.value, tt.pgUnit)
+		if err != nil || got != tt.want {
+			t.Errorf("%s with a setting of %g %s = %v, %v; want %v", tt.requirement, tt.value, tt.pgUnit, got, err, tt.want)
+		}
+	}
+}
+
+func TestSettingMeetsUnitMismatch(t *testing.T) {
+	for _, tt := range []struct {
+		requirement string
+		pgUnit      string
+		wantErr     string
+	}{
+		{"work_mem>=5s", "kB", "setting 'work_mem' can't be compared with '5s' (unit mismatch)"},
+		{"statement_timeout>=4MB", "ms", "(unit mismatch)"},
+		{"max_connections>=1kB", "", "(unit mismatch)"},
+		{"work_mem>=4MB", "XB", "unrecognized setting unit 'XB'"},
+	} {
+		req, err := parseSettingRequirement(tt.requirement)
+		if err != nil {
+			t.Fatalf("parseSettingRequirement(%q) error = %v", tt.requirement, err)
+		}
+		if _, err := settingMeets(req, 100, tt.pgUnit); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s with a setting in %q: error = %v, want %q", tt.requirement, tt.pgUnit, err, tt.wantErr)
+		}
+	}
+}
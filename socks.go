@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// socksProxyFromEnv returns ALL_PROXY (or all_proxy) if it names a SOCKS5 proxy. Proxies of
+// other kinds can't carry the PostgreSQL protocol, so they're left to the tools that use them.
+func socksProxyFromEnv() string {
+	for _, name := range []string{"ALL_PROXY", "all_proxy"} {
+		if value := os.Getenv(name); value != "" {
+			if u, err := url.Parse(value); err == nil && (u.Scheme == "socks5" || u.Scheme == "socks5h") {
+				return value
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// parseSocksProxy parses a -socks5 value, socks5://[user:password@]host[:port] (port 1080 if
+// left out). With socks5h:// the proxy resolves the database host name instead of us, for names
+// only known behind it. A bare host:port is taken as socks5h.
+func parseSocksProxy(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		if u, err = url.Parse("socks5h://" + value); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("'%s' is not a socks5://host:port URL", value)
+		}
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported proxy scheme '%s', use socks5 or socks5h", u.Scheme)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "1080")
+	}
+	if username := u.User.Username(); len(username) > 255 {
+		return nil, errors.New("proxy user name is longer than 255 bytes")
+	}
+	if password, _ := u.User.Password(); len(password) > 255 {
+		return nil, errors.New("proxy password is longer than 255 bytes")
+	}
+	return u, nil
+}
+
+// useSocksProxy routes the TCP connections of cfg (and its fallbacks) through the proxy. Unix
+// sockets are still dialed directly. The dial and the proxy handshake together are bounded by
+// dialTimeout, on top of the connect context's deadline.
+func useSocksProxy(cfg *pgx.ConnConfig, proxy *url.URL, dialTimeout time.Duration) {
+	d := socksDialer{proxy: proxy, dialer: &net.Dialer{Timeout: dialTimeout, KeepAlive: 5 * time.Minute}}
+	cfg.DialFunc = d.DialContext
+	if proxy.Scheme == "socks5h" {
+		// Pass the host name on for the proxy to resolve, rather than looking it up here.
+		cfg.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+			return []string{host}, nil
+		}
+	}
+}
+
+// socksDialer connects through a SOCKS5 proxy (RFC 1928), with optional user name and password
+// authentication (RFC 1929).
+type socksDialer struct {
+	proxy  *url.URL
+	dialer *net.Dialer
+}
+
+func (d socksDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network == "unix" {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+	if d.dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.dialer.Timeout)
+		defer cancel()
+	}
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("socks5 proxy %s: %w", d.proxy.Host, err)
+	}
+	// Bound the handshake by the context's deadline, and unblock it if the context is cancelled.
+	// The deadline is cleared again once it's done, since it would cut the connection short.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	err = d.handshake(conn, address)
+	if !stop() || err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("socks5 proxy %s: %w", d.proxy.Host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socksReplies are the RFC 1928 reply codes, by value.
+var socksReplies = map[byte]string{
+	1: "general server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// handshake asks the proxy on conn to connect to address, a host:port.
+func (d socksDialer) handshake(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port in '%s'", address)
+	}
+
+	methods := []byte{0x00} // No authentication
+	if d.proxy.User != nil {
+		methods = []byte{0x02} // User name and password, and nothing less
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch {
+	case reply[0] != 5:
+		return fmt.Errorf("not a SOCKS5 proxy (version %d)", reply[0])
+	case reply[1] != methods[0]:
+		return errors.New("proxy accepts none of our authentication methods")
+	case reply[1] == 0x02:
+		username := d.proxy.User.Username()
+		password, _ := d.proxy.User.Password()
+		auth := append([]byte{1, byte(len(username))}, username...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[0] != 1 {
+			return fmt.Errorf("unexpected authentication reply version %d from proxy", reply[0])
+		}
+		if reply[1] != 0 {
+			return errors.New("proxy rejected the user name or password")
+		}
+	}
+
+	req := []byte{5, 1, 0} // CONNECT
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name '%s' is too long for SOCKS5", host)
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// The reply holds the proxy's bound address, which we don't need but must read past.
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		msg, ok := socksReplies[head[1]]
+		if !ok {
+			msg = fmt.Sprintf("error %d", head[1])
+		}
+		return fmt.Errorf("can't connect to %s: %s", address, msg)
+	}
+	var skip int
+	switch head[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0]) + 2
+	default:
+		return fmt.Errorf("unknown address type %d in proxy reply", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"testing"
+)
+
+func TestCurrentBuildInfo(t *testing.T) {
+	oldVersion, oldCommit, oldDate := version, commit, buildDate
+	t.Cleanup(func() { version, commit, buildDate = oldVersion, oldCommit, oldDate })
+
+	version, commit, buildDate = "1.2.3", "abc123", "2024-10-15T12:00:00Z"
+	info := currentBuildInfo()
+	want := buildInfo{Version: "1.2.3", Commit: "abc123", BuildDate: "2024-10-15T12:00:00Z", GoVersion: runtime.Version()}
+	if info != want {
+		t.Errorf("currentBuildInfo() = %+v, want %+v", info, want)
+	}
+}
+
+func TestCurrentBuildInfoDefaults(t *testing.T) {
+	oldCommit, oldDate := commit, buildDate
+	t.Cleanup(func() { commit, buildDate = oldCommit, oldDate })
+
+	commit, buildDate = "", ""
+	info := currentBuildInfo()
+	if info.Version != version {
+		t.Errorf("Version = %q, want %q", info.Version, version)
+	}
+	// A test binary has no VCS stamp, so both fall back
+	if info.Commit == "" || info.BuildDate == "" {
+		t.Errorf("Commit = %q, BuildDate = %q, want them filled in", info.Commit, info.BuildDate)
+	}
+}
+
+func TestPrintVersionInfo(t *testing.T) {
+	info := buildInfo{Version: "1.2.3", Commit: "abc123", BuildDate: "2024-10-15T12:00:00Z", GoVersion: "go1.24.1"}
+
+	var text bytes.Buffer
+	if err := printVersionInfo(&text, info, false); err != nil {
+		t.Fatalf("printVersionInfo() error = %v", err)
+	}
+	wantText := "pg_ready_check 1.2.3 (commit abc123, built 2024-10-15T12:00:00Z, go1.24.1)\n"
+	if text.String() != wantText {
+		t.Errorf("text output = %q, want %q", text.String(), wantText)
+	}
+
+	var js bytes.Buffer
+	if err := printVersionInfo(&js, info, true); err != nil {
+		t.Fatalf("printVersionInfo() error = %v", err)
+	}
+	var decoded map[string]string
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("JSON output %q doesn't parse: %v", js.String(), err)
+	}
+	want := map[string]string{"version": "1.2.3", "commit": "abc123", "build_date": "2024-10-15T12:00:00Z", "go_version": "go1.24.1"}
+	for k, v := range want {
+		if decoded[k] != v {
+			t.Errorf("JSON %s = %q, want %q", k, decoded[k], v)
+		}
+	}
+}